@@ -0,0 +1,247 @@
+package micro
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// the default header carrying the api key
+const defaultAPIKeyHeader = "X-API-Key"
+
+// ErrAPIKeyNotFound is returned by a KeyStore if the key does not exist
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey is the information of an api key
+type APIKey struct {
+	// Principal is the owner of the key, it is set as the sub claim
+	Principal string `yaml:"principal" json:"principal"`
+	// Tier is the quota tier of the key
+	Tier string `yaml:"tier" json:"tier"`
+	// Roles are the roles of the key, they are set as the roles claim
+	Roles []string `yaml:"roles" json:"roles"`
+}
+
+// KeyStore validates the api keys
+type KeyStore interface {
+	// Lookup returns the information of the key, it returns ErrAPIKeyNotFound if the key does not exist
+	Lookup(ctx context.Context, key string) (*APIKey, error)
+}
+
+// HashAPIKey returns the hash of the api key which is stored in the key stores
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// MemoryKeyStore is the in-memory KeyStore which only keeps the hashes of the keys
+type MemoryKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey
+}
+
+// NewMemoryKeyStore creates a new in-memory key store
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{
+		keys: make(map[string]*APIKey),
+	}
+}
+
+// Add adds a plain api key
+func (m *MemoryKeyStore) Add(key string, info *APIKey) {
+	m.AddHashed(HashAPIKey(key), info)
+}
+
+// AddHashed adds an api key by its hash, see HashAPIKey
+func (m *MemoryKeyStore) AddHashed(hash string, info *APIKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys[hash] = info
+}
+
+// Lookup implements KeyStore interface
+func (m *MemoryKeyStore) Lookup(ctx context.Context, key string) (*APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	info, ok := m.keys[HashAPIKey(key)]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	return info, nil
+}
+
+func (m *MemoryKeyStore) replace(keys map[string]*APIKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys = keys
+}
+
+// FileKeyStore is the KeyStore loaded from a YAML or JSON file, for example:
+//
+//	keys:
+//	  - hash: sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    principal: partner
+//	    tier: gold
+//	    roles: [reader]
+type FileKeyStore struct {
+	MemoryKeyStore
+	file string
+}
+
+type keyStoreFile struct {
+	Keys []struct {
+		APIKey `yaml:",inline"`
+		// Hash is the hash of the key, see HashAPIKey
+		Hash string `yaml:"hash"`
+		// Key is the plain key, it should only be used in development
+		Key string `yaml:"key"`
+	} `yaml:"keys"`
+}
+
+// NewFileKeyStore creates a new key store loaded from the file
+func NewFileKeyStore(file string) (*FileKeyStore, error) {
+	store := &FileKeyStore{file: file}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Reload reloads the keys from the file, the keys are kept if the file is invalid
+func (f *FileKeyStore) Reload() error {
+	data, err := ioutil.ReadFile(f.file)
+	if err != nil {
+		return err
+	}
+
+	var content keyStoreFile
+	if err := yaml.Unmarshal(data, &content); err != nil {
+		return fmt.Errorf("key store %s: %v", f.file, err)
+	}
+
+	keys := make(map[string]*APIKey)
+	for i, k := range content.Keys {
+		info := k.APIKey
+		switch {
+		case k.Hash != "":
+			keys[strings.ToLower(k.Hash)] = &info
+		case k.Key != "":
+			keys[HashAPIKey(k.Key)] = &info
+		default:
+			return fmt.Errorf("key store %s: key %d has neither hash nor key", f.file, i)
+		}
+	}
+
+	f.replace(keys)
+
+	return nil
+}
+
+// APIKeyOpts is configures for the api key authenticator
+type APIKeyOpts struct {
+	// Store is the key store to validate the keys
+	Store KeyStore
+	// Header is the http header and gRPC metadata carrying the key, default is X-API-Key
+	Header string
+	// QueryParam is the http query parameter carrying the key, it is disabled if empty
+	QueryParam string
+	// SkipMethods are the full method names which do not require the key, path.Match patterns
+	// like /package.Service/* are supported
+	SkipMethods []string
+}
+
+// APIKeyAuthenticator authenticates the requests with the api keys
+type APIKeyAuthenticator struct {
+	opts     APIKeyOpts
+	metadata string
+}
+
+type apiKeyKey struct{}
+
+// NewAPIKeyAuthenticator creates a new api key authenticator
+func NewAPIKeyAuthenticator(opts APIKeyOpts) (*APIKeyAuthenticator, error) {
+	if opts.Store == nil {
+		return nil, errors.New("no key store for api key authenticator")
+	}
+
+	if opts.Header == "" {
+		opts.Header = defaultAPIKeyHeader
+	}
+
+	return &APIKeyAuthenticator{
+		opts:     opts,
+		metadata: strings.ToLower(opts.Header),
+	}, nil
+}
+
+// Authenticate implements Authenticator interface, the principal and roles of the key are set as claims
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if matchMethods(a.opts.SkipMethods, fullMethod) {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(a.metadata)
+	if len(keys) == 0 || keys[0] == "" {
+		return ctx, ErrMissingCredentials
+	}
+
+	info, err := a.opts.Store.Lookup(ctx, keys[0])
+	if err == ErrAPIKeyNotFound {
+		return ctx, status.Error(codes.Unauthenticated, "invalid api key")
+	}
+	if err != nil {
+		return ctx, status.Errorf(codes.Unavailable, "failed to validate api key: %v", err)
+	}
+
+	roles := make([]interface{}, 0, len(info.Roles))
+	for _, role := range info.Roles {
+		roles = append(roles, role)
+	}
+
+	ctx = NewClaimsContext(ctx, Claims{"sub": info.Principal, "tier": info.Tier, "roles": roles})
+
+	return NewAPIKeyContext(ctx, info), nil
+}
+
+// Annotate forwards the key in the http header or query parameter to gRPC metadata, it is installed
+// as an annotator by the Authentication option
+func (a *APIKeyAuthenticator) Annotate(ctx context.Context, r *http.Request) metadata.MD {
+	key := r.Header.Get(a.opts.Header)
+	if key == "" && a.opts.QueryParam != "" {
+		key = r.URL.Query().Get(a.opts.QueryParam)
+	}
+
+	if key == "" {
+		return nil
+	}
+
+	return metadata.Pairs(a.metadata, key)
+}
+
+// NewAPIKeyContext returns a new context carrying the api key information
+func NewAPIKeyContext(ctx context.Context, info *APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, info)
+}
+
+// APIKeyFromContext returns the api key information stored in the context
+func APIKeyFromContext(ctx context.Context) (*APIKey, bool) {
+	info, ok := ctx.Value(apiKeyKey{}).(*APIKey)
+	return info, ok
+}
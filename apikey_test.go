@@ -0,0 +1,118 @@
+package micro
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestMemoryKeyStore(t *testing.T) {
+	var should = require.New(t)
+
+	store := NewMemoryKeyStore()
+	store.Add("secret", &APIKey{Principal: "partner", Tier: "gold"})
+
+	info, err := store.Lookup(context.Background(), "secret")
+	should.NoError(err)
+	should.Equal("partner", info.Principal)
+
+	_, err = store.Lookup(context.Background(), "other")
+	should.Equal(ErrAPIKeyNotFound, err)
+}
+
+func TestFileKeyStore(t *testing.T) {
+	var should = require.New(t)
+
+	dir, err := ioutil.TempDir("", "keystore")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "keys.yaml")
+	should.NoError(ioutil.WriteFile(file, []byte(`
+keys:
+  - hash: `+HashAPIKey("hashed")+`
+    principal: partner
+    tier: gold
+    roles: [reader]
+  - key: plain
+    principal: dev
+`), 0644))
+
+	store, err := NewFileKeyStore(file)
+	should.NoError(err)
+
+	info, err := store.Lookup(context.Background(), "hashed")
+	should.NoError(err)
+	should.Equal("gold", info.Tier)
+	should.Equal([]string{"reader"}, info.Roles)
+
+	info, err = store.Lookup(context.Background(), "plain")
+	should.NoError(err)
+	should.Equal("dev", info.Principal)
+
+	// invalid file keeps the loaded keys
+	should.NoError(ioutil.WriteFile(file, []byte(`keys: [{principal: nobody}]`), 0644))
+	should.Error(store.Reload())
+	_, err = store.Lookup(context.Background(), "plain")
+	should.NoError(err)
+
+	_, err = NewFileKeyStore(filepath.Join(dir, "not-exist.yaml"))
+	should.Error(err)
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	var should = require.New(t)
+
+	_, err := NewAPIKeyAuthenticator(APIKeyOpts{})
+	should.Error(err)
+
+	store := NewMemoryKeyStore()
+	store.Add("secret", &APIKey{Principal: "partner", Tier: "gold", Roles: []string{"reader"}})
+	a, err := NewAPIKeyAuthenticator(APIKeyOpts{Store: store, QueryParam: "api_key"})
+	should.NoError(err)
+
+	// the key is forwarded from the header or the query parameter
+	req := httptest.NewRequest("GET", "/v1/hello", nil)
+	req.Header.Set("X-API-Key", "secret")
+	should.Equal([]string{"secret"}, a.Annotate(context.Background(), req).Get("x-api-key"))
+
+	req = httptest.NewRequest("GET", "/v1/hello?api_key=secret", nil)
+	should.Equal([]string{"secret"}, a.Annotate(context.Background(), req).Get("x-api-key"))
+
+	req = httptest.NewRequest("GET", "/v1/hello", nil)
+	should.Nil(a.Annotate(context.Background(), req))
+
+	// the principal and tier are attached to the context
+	s := NewService(Authentication(a))
+	should.Len(s.annotators, 1)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		key, _ := APIKeyFromContext(ctx)
+		claims, _ := ClaimsFromContext(ctx)
+		should.Equal("partner", claims.Subject())
+		should.Equal([]string{"reader"}, claims.Strings("roles"))
+		return key.Tier, nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "secret"))
+	resp, err := s.authUnaryInterceptor(ctx, nil, info, handler)
+	should.NoError(err)
+	should.Equal("gold", resp)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "invalid"))
+	_, err = s.authUnaryInterceptor(ctx, nil, info, handler)
+	should.Equal(codes.Unauthenticated, status.Code(err))
+
+	_, err = s.authUnaryInterceptor(context.Background(), nil, info, handler)
+	should.Equal(ErrMissingCredentials, err)
+}
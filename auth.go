@@ -0,0 +1,224 @@
+package micro
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ErrMissingCredentials is returned by an Authenticator if the request does not carry its credentials,
+// the next authenticator will be tried in this case
+var ErrMissingCredentials = status.Error(codes.Unauthenticated, "missing credentials")
+
+// Authenticator authenticates the incoming requests
+type Authenticator interface {
+	// Authenticate authenticates the request of the full method and returns the context carrying
+	// the authentication result, it returns ErrMissingCredentials if the credentials are absent
+	Authenticate(ctx context.Context, fullMethod string) (context.Context, error)
+}
+
+// AuthenticatorFunc is a bridge between Authenticator and an ordinary function
+type AuthenticatorFunc func(ctx context.Context, fullMethod string) (context.Context, error)
+
+// Authenticate implements Authenticator interface
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	return f(ctx, fullMethod)
+}
+
+// Claims are the claims of the authenticated token
+type Claims map[string]interface{}
+
+type claimsKey struct{}
+
+// Subject returns the sub claim
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// Issuer returns the iss claim
+func (c Claims) Issuer() string {
+	iss, _ := c["iss"].(string)
+	return iss
+}
+
+// Audience returns the aud claim which can be either a string or an array
+func (c Claims) Audience() []string {
+	return c.Strings("aud")
+}
+
+// ExpiresAt returns the exp claim
+func (c Claims) ExpiresAt() (time.Time, bool) {
+	return c.time("exp")
+}
+
+// Strings returns the claim as a string slice, a single string claim is split by spaces
+// which is the format of the scope claim
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(v), 0), true
+}
+
+// NewClaimsContext returns a new context carrying the claims
+func NewClaimsContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims stored in the context
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
+
+// bearerToken returns the bearer token in the authorization metadata, the gateway forwards the
+// Authorization http header as this metadata
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	for _, value := range md.Get("authorization") {
+		parts := strings.SplitN(value, " ", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "bearer") && parts[1] != "" {
+			return strings.TrimSpace(parts[1]), true
+		}
+	}
+
+	return "", false
+}
+
+// matchMethods checks if the full method matches any of the patterns, see path.Match for the syntax
+func matchMethods(patterns []string, fullMethod string) bool {
+	for _, pattern := range patterns {
+		if pattern == fullMethod {
+			return true
+		}
+		if ok, _ := path.Match(pattern, fullMethod); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// methodMatcher finds the key of the full method among the exact names and the patterns, the patterns are
+// ordered by specificity once so that overlapping patterns like /pkg.Svc/* and /* match deterministically
+type methodMatcher struct {
+	exact    map[string]bool
+	patterns []string
+}
+
+// newMethodMatcher orders the patterns with the longer literal prefix first, then the longer ones
+func newMethodMatcher(keys []string) *methodMatcher {
+	m := &methodMatcher{exact: make(map[string]bool)}
+	for _, key := range keys {
+		m.exact[key] = true
+		if strings.ContainsAny(key, `*?[\`) {
+			m.patterns = append(m.patterns, key)
+		}
+	}
+
+	sort.Slice(m.patterns, func(i, j int) bool {
+		a, b := m.patterns[i], m.patterns[j]
+		if prefixA, prefixB := literalPrefixLen(a), literalPrefixLen(b); prefixA != prefixB {
+			return prefixA > prefixB
+		}
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a < b
+	})
+
+	return m
+}
+
+// literalPrefixLen returns the length of the pattern before the first special character
+func literalPrefixLen(pattern string) int {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return i
+	}
+
+	return len(pattern)
+}
+
+// match returns the exact name or the most specific pattern matching the full method
+func (m *methodMatcher) match(fullMethod string) (string, bool) {
+	if m.exact[fullMethod] {
+		return fullMethod, true
+	}
+
+	for _, pattern := range m.patterns {
+		if ok, _ := path.Match(pattern, fullMethod); ok {
+			return pattern, true
+		}
+	}
+
+	return "", false
+}
+
+// authenticate tries the authenticators in order until one of them accepts the credentials
+func (s *Service) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	for _, authenticator := range s.authenticators {
+		newCtx, err := authenticator.Authenticate(ctx, fullMethod)
+		if err == ErrMissingCredentials {
+			continue
+		}
+
+		return newCtx, err
+	}
+
+	return nil, ErrMissingCredentials
+}
+
+func (s *Service) authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (s *Service) authStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	wrapped := grpc_middleware.WrapServerStream(stream)
+	wrapped.WrappedContext = ctx
+
+	return handler(srv, wrapped)
+}
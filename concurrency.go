@@ -0,0 +1,344 @@
+package micro
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the scope label of the global concurrency limit metrics
+const globalScope = "global"
+
+// errOverloaded is returned when the concurrency limit is reached
+var errOverloaded = status.Error(codes.Unavailable, "server is overloaded, please retry later")
+
+// LimitAlgorithm adapts the concurrency limit with the latency samples
+type LimitAlgorithm interface {
+	// Update returns the new limit with the latency of a finished request, inflight is the number of
+	// in-flight requests when it started and dropped is whether it timed out or was shed downstream
+	Update(limit int, rtt time.Duration, inflight int, dropped bool) int
+}
+
+// ConcurrencyLimitOpts is configures for concurrency limiting
+type ConcurrencyLimitOpts struct {
+	// Limit is the maximum number of in-flight requests of both gRPC and http servers, it is the
+	// initial limit if Algorithm is set, zero means unlimited
+	Limit int
+	// Methods are the maximum numbers of in-flight requests of gRPC methods, the key is the full method
+	// name, path.Match patterns like /package.Service/* are supported and share the limit
+	Methods map[string]int
+	// Algorithm adapts the global limit, the limit is static if nil
+	Algorithm LimitAlgorithm
+	// Name is the service label of the metrics which tells apart the services in the same process
+	Name string
+}
+
+// AIMDLimit is the additive increase multiplicative decrease algorithm, the limit is increased by one
+// if the requests are fast and decreased by the backoff ratio if they are slow or dropped
+type AIMDLimit struct {
+	// MinLimit is the lower bound of the limit, default is 1
+	MinLimit int
+	// MaxLimit is the upper bound of the limit, default is 1000
+	MaxLimit int
+	// Timeout is the latency above which the request is considered slow
+	Timeout time.Duration
+	// BackoffRatio is the ratio to decrease the limit, default is 0.9
+	BackoffRatio float64
+}
+
+// Update implements LimitAlgorithm interface
+func (a *AIMDLimit) Update(limit int, rtt time.Duration, inflight int, dropped bool) int {
+	ratio := a.BackoffRatio
+	if ratio <= 0 || ratio >= 1 {
+		ratio = 0.9
+	}
+
+	if dropped || (a.Timeout > 0 && rtt > a.Timeout) {
+		limit = int(float64(limit) * ratio)
+	} else if inflight*2 >= limit {
+		// only grow when the limit is actually used
+		limit++
+	}
+
+	return clampLimit(limit, a.MinLimit, a.MaxLimit)
+}
+
+// GradientLimit adapts the limit by the gradient between the long term and the current latency, the
+// limit shrinks when the latency grows which indicates the requests are queueing up
+type GradientLimit struct {
+	// MinLimit is the lower bound of the limit, default is 1
+	MinLimit int
+	// MaxLimit is the upper bound of the limit, default is 1000
+	MaxLimit int
+	// Smoothing is the factor to smooth the limit changes, default is 0.2
+	Smoothing float64
+
+	longRTT float64
+}
+
+// Update implements LimitAlgorithm interface
+func (g *GradientLimit) Update(limit int, rtt time.Duration, inflight int, dropped bool) int {
+	smoothing := g.Smoothing
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 0.2
+	}
+
+	short := float64(rtt)
+	if g.longRTT == 0 {
+		g.longRTT = short
+	}
+	g.longRTT = g.longRTT*0.95 + short*0.05
+
+	// the requests are not limited by the concurrency, keep the limit
+	if inflight*2 < limit && !dropped {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.longRTT/short))
+	if dropped {
+		gradient = 0.5
+	}
+
+	// allow a queue of the square root of the limit to probe for more capacity
+	newLimit := float64(limit)*gradient + math.Sqrt(float64(limit))
+	newLimit = float64(limit)*(1-smoothing) + newLimit*smoothing
+
+	return clampLimit(int(newLimit), g.MinLimit, g.MaxLimit)
+}
+
+func clampLimit(limit, min, max int) int {
+	if min <= 0 {
+		min = 1
+	}
+	if max <= 0 {
+		max = 1000
+	}
+
+	if limit < min {
+		return min
+	}
+	if limit > max {
+		return max
+	}
+
+	return limit
+}
+
+// concurrencyLimiter counts the in-flight requests and sheds the excess load
+type concurrencyLimiter struct {
+	mu             sync.Mutex
+	service        *Service
+	limit          int
+	inflight       int
+	algorithm      LimitAlgorithm
+	methods        map[string]int
+	matcher        *methodMatcher
+	methodInflight map[string]int
+	// the service label of the metrics
+	name string
+}
+
+func newConcurrencyLimiter(s *Service, opts ConcurrencyLimitOpts) *concurrencyLimiter {
+	c := &concurrencyLimiter{
+		service:        s,
+		limit:          opts.Limit,
+		algorithm:      opts.Algorithm,
+		methods:        opts.Methods,
+		methodInflight: make(map[string]int),
+		name:           opts.Name,
+	}
+
+	keys := make([]string, 0, len(c.methods))
+	concurrencyLimitGauge.WithLabelValues(c.name, globalScope).Set(float64(c.limit))
+	for key, limit := range c.methods {
+		keys = append(keys, key)
+		concurrencyLimitGauge.WithLabelValues(c.name, key).Set(float64(limit))
+	}
+	c.matcher = newMethodMatcher(keys)
+
+	return c
+}
+
+// acquire takes a slot of the global limit, it returns the number of in-flight requests
+func (c *concurrencyLimiter) acquire() (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.limit > 0 && c.inflight >= c.limit {
+		concurrencyRejectedCounter.WithLabelValues(c.name, globalScope).Inc()
+		return c.inflight, false
+	}
+
+	c.inflight++
+	concurrencyInflightGauge.WithLabelValues(c.name, globalScope).Set(float64(c.inflight))
+
+	return c.inflight, true
+}
+
+// release returns the slot of the global limit and adapts the limit with the latency
+func (c *concurrencyLimiter) release(start time.Time, inflight int, dropped bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inflight--
+	concurrencyInflightGauge.WithLabelValues(c.name, globalScope).Set(float64(c.inflight))
+
+	if c.algorithm != nil && c.limit > 0 {
+		c.limit = c.algorithm.Update(c.limit, time.Since(start), inflight, dropped)
+		concurrencyLimitGauge.WithLabelValues(c.name, globalScope).Set(float64(c.limit))
+	}
+}
+
+// methodKey returns the key of the method limit, the exact match takes precedence over patterns
+func (c *concurrencyLimiter) methodKey(fullMethod string) (string, int) {
+	if key, ok := c.matcher.match(fullMethod); ok {
+		return key, c.methods[key]
+	}
+
+	return "", 0
+}
+
+// acquireMethod takes a slot of the method limit, the returned release function should be called
+// when the request finishes
+func (c *concurrencyLimiter) acquireMethod(fullMethod string) (func(), bool) {
+	key, limit := c.methodKey(fullMethod)
+	if limit <= 0 {
+		return func() {}, true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.methodInflight[key] >= limit {
+		concurrencyRejectedCounter.WithLabelValues(c.name, key).Inc()
+		return nil, false
+	}
+
+	c.methodInflight[key]++
+	concurrencyInflightGauge.WithLabelValues(c.name, key).Set(float64(c.methodInflight[key]))
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.methodInflight[key]--
+		concurrencyInflightGauge.WithLabelValues(c.name, key).Set(float64(c.methodInflight[key]))
+	}, true
+}
+
+// guard runs the gRPC handler within the limits, the requests forwarded by the gateway and the gRPC-Web and
+// Connect calls have been counted by the http middleware so only the method limits are applied to them
+func (c *concurrencyLimiter) guard(ctx context.Context, fullMethod string, handler func() error) (err error) {
+	if !c.service.fromGateway(ctx) && !fromGRPCHTTP(ctx) {
+		inflight, ok := c.acquire()
+		if !ok {
+			return errOverloaded
+		}
+
+		start := time.Now()
+		defer func() {
+			code := status.Code(err)
+			c.release(start, inflight, code == codes.DeadlineExceeded || code == codes.Unavailable)
+		}()
+	}
+
+	releaseMethod, ok := c.acquireMethod(fullMethod)
+	if !ok {
+		return errOverloaded
+	}
+	defer releaseMethod()
+
+	return handler()
+}
+
+func (c *concurrencyLimiter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var resp interface{}
+	err := c.guard(ctx, info.FullMethod, func() (err error) {
+		resp, err = handler(ctx, req)
+		return err
+	})
+
+	return resp, err
+}
+
+func (c *concurrencyLimiter) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return c.guard(stream.Context(), info.FullMethod, func() error {
+		return handler(srv, stream)
+	})
+}
+
+// middleware applies the global limit to the http requests, the excess requests are rejected with 503
+func (c *concurrencyLimiter) middleware(next http.Handler) http.Handler {
+	s := c.service
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inflight, ok := c.acquire()
+		if !ok {
+			_, outboundMarshaler := runtime.MarshalerForRequest(s.mux, r)
+			s.errorHandler(r.Context(), s.mux, outboundMarshaler, w, r, errOverloaded)
+			return
+		}
+
+		// the request is dropped if it timed out or was shed downstream
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		defer func() {
+			c.release(start, inflight, rec.status == http.StatusServiceUnavailable || rec.status == http.StatusGatewayTimeout)
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// statusRecorder records the status of the response, http.Flusher and http.Hijacker of the writer are kept
+// for the streaming and WebSocket responses
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter interface
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter interface
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	return rec.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher interface
+func (rec *statusRecorder) Flush() {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker interface
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := rec.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+
+	return nil, nil, errors.New("the response writer does not support hijacking")
+}
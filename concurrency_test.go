@@ -0,0 +1,186 @@
+package micro
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestConcurrencyLimitInterceptor(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(ConcurrencyLimit(ConcurrencyLimitOpts{
+		Limit:   2,
+		Methods: map[string]int{"/test.Service/*": 1},
+	}))
+	should.Len(s.unaryInterceptors, 4)
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		started <- struct{}{}
+		<-block
+		return nil, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+
+	go s.concurrencyLimiter.unaryInterceptor(context.Background(), nil, info, handler)
+	<-started
+
+	// the method limit is reached
+	_, err := s.concurrencyLimiter.unaryInterceptor(context.Background(), nil, info, handler)
+	should.Equal(codes.Unavailable, status.Code(err))
+
+	// the global limit is reached
+	other := &grpc.UnaryServerInfo{FullMethod: "/other.Service/Method"}
+	go s.concurrencyLimiter.unaryInterceptor(context.Background(), nil, other, handler)
+	<-started
+	_, err = s.concurrencyLimiter.unaryInterceptor(context.Background(), nil, other, handler)
+	should.Equal(codes.Unavailable, status.Code(err))
+
+	// the requests forwarded by the gateway are only counted by the method limits
+	req := httptest.NewRequest("GET", "/", nil)
+	ctx := metadata.NewIncomingContext(context.Background(), s.clientIPAnnotator(context.Background(), req))
+	go s.concurrencyLimiter.unaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/third.Service/Method"}, handler)
+	<-started
+
+	close(block)
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(ConcurrencyLimit(ConcurrencyLimitOpts{Limit: 1}))
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	handler := s.concurrencyLimiter.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-block
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	should.Equal(http.StatusServiceUnavailable, recorder.Code)
+
+	close(block)
+}
+
+func TestConcurrencyLimitMiddlewareDropped(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(ConcurrencyLimit(ConcurrencyLimitOpts{
+		Limit:     10,
+		Algorithm: &AIMDLimit{},
+		Name:      "dropped",
+	}))
+	should.Equal(float64(10), testutil.ToFloat64(concurrencyLimitGauge.WithLabelValues("dropped", globalScope)))
+
+	code := http.StatusOK
+	handler := s.concurrencyLimiter.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the writer is still a flusher
+		w.WriteHeader(code)
+		w.(http.Flusher).Flush()
+	}))
+
+	// the requests timed out downstream shrink the limit
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	should.Equal(10, s.concurrencyLimiter.limit)
+
+	code = http.StatusGatewayTimeout
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	should.Equal(9, s.concurrencyLimiter.limit)
+	should.Equal(float64(9), testutil.ToFloat64(concurrencyLimitGauge.WithLabelValues("dropped", globalScope)))
+}
+
+func TestAIMDLimit(t *testing.T) {
+	var should = require.New(t)
+
+	aimd := &AIMDLimit{MinLimit: 5, MaxLimit: 20, Timeout: time.Second}
+
+	should.Equal(11, aimd.Update(10, time.Millisecond, 5, false))
+	should.Equal(10, aimd.Update(10, time.Millisecond, 1, false))
+	should.Equal(9, aimd.Update(10, 2*time.Second, 5, false))
+	should.Equal(9, aimd.Update(10, time.Millisecond, 5, true))
+	should.Equal(5, aimd.Update(5, time.Millisecond, 5, true))
+	should.Equal(20, aimd.Update(20, time.Millisecond, 20, false))
+}
+
+func TestGradientLimit(t *testing.T) {
+	var should = require.New(t)
+
+	gradient := &GradientLimit{MaxLimit: 200}
+
+	// stable latency grows the limit
+	limit := 100
+	for i := 0; i < 10; i++ {
+		limit = gradient.Update(limit, 10*time.Millisecond, limit, false)
+	}
+	should.Greater(limit, 100)
+
+	// increasing latency shrinks the limit
+	grown := limit
+	for i := 0; i < 10; i++ {
+		limit = gradient.Update(limit, 100*time.Millisecond, limit, false)
+	}
+	should.Less(limit, grown)
+
+	// app-limited requests keep the limit
+	should.Equal(limit, gradient.Update(limit, time.Second, 1, false))
+}
+
+func TestConcurrencyLimitGRPCHTTP(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(
+		GRPCWeb(),
+		Connect(),
+		Health(health.NewServer()),
+		ConcurrencyLimit(ConcurrencyLimitOpts{Limit: 1}),
+		PreShutdownDelay(0),
+	)
+	go s.Start(32888, 32999, noopReverseProxyFunc)
+	defer s.Stop()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	call := func(contentType string, body []byte) *http.Response {
+		resp, err := http.Post("http://127.0.0.1:32888/grpc.health.v1.Health/Check", contentType, bytes.NewReader(body))
+		should.NoError(err)
+		return resp
+	}
+
+	// the gRPC-Web call takes only the slot of the http middleware
+	resp := call("application/grpc-web+proto", grpcFrame(0, nil))
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	should.NoError(err)
+	should.Equal(http.StatusOK, resp.StatusCode)
+	reader := bytes.NewReader(body)
+	readGRPCWebFrame(t, reader)
+	should.Equal("grpc-status: 0\r\n", string(readGRPCWebFrame(t, reader).data))
+
+	// so does the Connect call
+	resp = call("application/json", []byte(`{}`))
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	should.NoError(err)
+	should.Equal(http.StatusOK, resp.StatusCode)
+	should.JSONEq(`{"status":"SERVING"}`, string(body))
+}
@@ -0,0 +1,340 @@
+package micro
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the service which can be loaded from a YAML or JSON file and the
+// environment, see LoadConfig
+type Config struct {
+	// HTTPPort is the port of the http server, default is 8888
+	HTTPPort uint `yaml:"http_port" json:"http_port"`
+	// GRPCPort is the port of the gRPC server, default is 9999
+	GRPCPort uint `yaml:"grpc_port" json:"grpc_port"`
+	// ShutdownTimeout is the timeout before the server shutdown abruptly
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" json:"shutdown_timeout"`
+	// PreShutdownDelay is the maximum time waiting for the in-flight requests to finish before the shutdown starts
+	PreShutdownDelay time.Duration `yaml:"pre_shutdown_delay" json:"pre_shutdown_delay"`
+	// DefaultTimeout is the server-side timeout of the gRPC requests, zero means no timeout
+	DefaultTimeout time.Duration `yaml:"default_timeout" json:"default_timeout"`
+	// MethodTimeouts are the server-side timeouts of the gRPC methods, see MethodTimeout
+	MethodTimeouts map[string]time.Duration `yaml:"method_timeouts" json:"method_timeouts"`
+	// StaticDir is the directory of the static files
+	StaticDir string `yaml:"static_dir" json:"static_dir"`
+	// Redoc is configures for redoc
+	Redoc RedocOpts `yaml:"redoc" json:"redoc"`
+	// TLS is the certificate files, both servers are insecure if the CertFile is not set
+	TLS TLSFiles `yaml:"tls" json:"tls"`
+	// Server is the connection and size limits of both servers
+	Server ServerConfig `yaml:"server" json:"server"`
+	// LogLevel is the log level which is one of debug, info and warn, default is info
+	LogLevel string `yaml:"log_level" json:"log_level"`
+	// CORSOrigins are the origins allowed to send cross-origin requests to the http server
+	CORSOrigins []string `yaml:"cors_origins" json:"cors_origins"`
+	// RateLimit is the rate limits, rate limiting is disabled if it is not set
+	RateLimit *RateLimitOpts `yaml:"rate_limit" json:"rate_limit"`
+
+	// the source of the config for reloading
+	file      string
+	envPrefix string
+}
+
+// TLSFiles is the PEM encoded certificate files for serving tls
+type TLSFiles struct {
+	// CertFile is the certificate of the servers, it should be valid for localhost which is dialed by the gateway
+	CertFile string `yaml:"cert_file" json:"cert_file"`
+	// KeyFile is the private key of the CertFile
+	KeyFile string `yaml:"key_file" json:"key_file"`
+	// CAFile is the CA to verify the server certificate by the gateway and the client certificates if
+	// ClientAuth is true, the system CAs are used if it is not set
+	CAFile string `yaml:"ca_file" json:"ca_file"`
+	// ClientCertFile is the certificate presented by the gateway when ClientAuth is true
+	ClientCertFile string `yaml:"client_cert_file" json:"client_cert_file"`
+	// ClientKeyFile is the private key of the ClientCertFile
+	ClientKeyFile string `yaml:"client_key_file" json:"client_key_file"`
+	// ClientAuth is whether to require and verify the client certificates
+	ClientAuth bool `yaml:"client_auth" json:"client_auth"`
+}
+
+// DefaultConfig returns the default config
+func DefaultConfig() *Config {
+	return &Config{
+		HTTPPort:         8888,
+		GRPCPort:         9999,
+		ShutdownTimeout:  defaultShutdownTimeout,
+		PreShutdownDelay: defaultPreShutdownDelay,
+		Server:           DefaultServerConfig(),
+	}
+}
+
+// LoadConfig loads the config on top of DefaultConfig from the file if it is not empty, then overrides
+// it with the environment variables if the envPrefix is not empty. The file can be either YAML or JSON,
+// the durations are written like 10s or 1m30s. The environment variable names are the upper-cased
+// paths of the fields joined by underscores, e.g. with the prefix MICRO:
+//
+//	MICRO_HTTP_PORT=8080
+//	MICRO_SHUTDOWN_TIMEOUT=10s
+//	MICRO_REDOC_UP=true
+//	MICRO_REDOC_SPEC_URLS='{Service: /swagger.json}'
+//	MICRO_SERVER_MAX_RECV_MSG_SIZE=8388608
+//
+// The config is validated before it is returned
+func LoadConfig(file string, envPrefix string) (*Config, error) {
+	c := DefaultConfig()
+
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		// JSON is a subset of YAML so both are decoded by the YAML decoder
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+		}
+	}
+
+	if envPrefix != "" {
+		if err := loadEnv(reflect.ValueOf(c).Elem(), strings.ToUpper(envPrefix)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	c.file = file
+	c.envPrefix = envPrefix
+
+	return c, nil
+}
+
+// loadEnv sets the fields of the struct from the environment variables named by their yaml tags
+func loadEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		name := prefix + "_" + strings.ToUpper(tag)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := loadEnv(field, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		// strings are taken as they are, other values are parsed as YAML so that durations and maps work
+		if field.Kind() == reflect.String {
+			field.SetString(value)
+			continue
+		}
+
+		if err := yaml.Unmarshal([]byte(value), field.Addr().Interface()); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates the config
+func (c *Config) Validate() error {
+	if c.HTTPPort == 0 || c.HTTPPort > 65535 {
+		return fmt.Errorf("invalid http_port: %d", c.HTTPPort)
+	}
+
+	if c.GRPCPort == 0 || c.GRPCPort > 65535 {
+		return fmt.Errorf("invalid grpc_port: %d", c.GRPCPort)
+	}
+
+	if c.HTTPPort == c.GRPCPort {
+		return errors.New("http_port and grpc_port must be different")
+	}
+
+	if c.ShutdownTimeout < 0 {
+		return errors.New("shutdown_timeout must not be negative")
+	}
+
+	if c.PreShutdownDelay < 0 {
+		return errors.New("pre_shutdown_delay must not be negative")
+	}
+
+	if c.DefaultTimeout < 0 {
+		return errors.New("default_timeout must not be negative")
+	}
+
+	for method, timeout := range c.MethodTimeouts {
+		if timeout < 0 {
+			return fmt.Errorf("method_timeouts: timeout of %s must not be negative", method)
+		}
+	}
+
+	if err := c.Redoc.Validate(); err != nil {
+		return fmt.Errorf("redoc: %v", err)
+	}
+
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("tls: %v", err)
+	}
+
+	if err := c.Server.Validate(); err != nil {
+		return fmt.Errorf("server: %v", err)
+	}
+
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		return fmt.Errorf("invalid log_level: %s", c.LogLevel)
+	}
+
+	return nil
+}
+
+// Options returns the options of the config, the ports should be passed to Start, e.g.
+//
+//	opts, err := config.Options()
+//	...
+//	s := NewService(append(opts, RouteOpt(route))...)
+//	s.Start(config.HTTPPort, config.GRPCPort, reverseProxyFunc)
+//
+// The service created with the options reloads the config from the same file and environment on
+// ReloadSignals, see Reload
+func (c *Config) Options() ([]Option, error) {
+	config := *c
+	opts := []Option{
+		ShutdownTimeout(c.ShutdownTimeout),
+		PreShutdownDelay(c.PreShutdownDelay),
+		StaticDir(c.StaticDir),
+		WithServerConfig(c.Server),
+		LogLevel(c.LogLevel),
+		// always enabled so that the origins can be added by reloading
+		CORS(c.CORSOrigins...),
+		func(s *Service) error {
+			s.config = &config
+			return nil
+		},
+	}
+
+	if c.DefaultTimeout > 0 {
+		opts = append(opts, DefaultTimeout(c.DefaultTimeout))
+	}
+
+	for method, timeout := range c.MethodTimeouts {
+		opts = append(opts, MethodTimeout(method, timeout))
+	}
+
+	if c.Redoc.Up {
+		redoc := c.Redoc
+		opts = append(opts, Redoc(&redoc))
+	}
+
+	if c.RateLimit != nil {
+		opts = append(opts, RateLimit(*c.RateLimit))
+	}
+
+	if c.TLS.CertFile != "" {
+		server, client, err := c.TLS.Load()
+		if err != nil {
+			return nil, err
+		}
+
+		// serve the certificate by GetCertificate so that it can be replaced by reloading
+		certificate := &atomic.Value{}
+		certificate.Store(&server.Certificates[0])
+		server.Certificates = nil
+		server.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certificate.Load().(*tls.Certificate), nil
+		}
+
+		opts = append(opts, TLS(server, client), func(s *Service) error {
+			s.certificate = certificate
+			return nil
+		})
+	}
+
+	return opts, nil
+}
+
+// Validate validates the tls files
+func (f TLSFiles) Validate() error {
+	if (f.CertFile == "") != (f.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+
+	if (f.ClientCertFile == "") != (f.ClientKeyFile == "") {
+		return errors.New("client_cert_file and client_key_file must be set together")
+	}
+
+	if f.ClientAuth {
+		if f.CertFile == "" {
+			return errors.New("client_auth requires cert_file")
+		}
+		if f.CAFile == "" {
+			return errors.New("client_auth requires ca_file")
+		}
+		if f.ClientCertFile == "" {
+			return errors.New("client_auth requires client_cert_file for the gateway")
+		}
+	}
+
+	return nil
+}
+
+// Load loads the tls configs of the servers and the gateway
+func (f TLSFiles) Load() (server *tls.Config, client *tls.Config, err error) {
+	cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	server = &tls.Config{Certificates: []tls.Certificate{cert}}
+	client = &tls.Config{}
+
+	if f.CAFile != "" {
+		pem, err := ioutil.ReadFile(f.CAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in %s", f.CAFile)
+		}
+		client.RootCAs = pool
+
+		if f.ClientAuth {
+			server.ClientAuth = tls.RequireAndVerifyClientCert
+			server.ClientCAs = pool
+		}
+	}
+
+	if f.ClientCertFile != "" {
+		clientCert, err := tls.LoadX509KeyPair(f.ClientCertFile, f.ClientKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		client.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return server, client, nil
+}
@@ -0,0 +1,190 @@
+package micro
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeDevCertificate writes the PEM encoded certificate and key into the dir
+func writeDevCertificate(t *testing.T, dir, name string, cert tls.Certificate) (string, string) {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+
+	return certFile, keyFile
+}
+
+func TestLoadConfig(t *testing.T) {
+	var should = require.New(t)
+
+	dir, err := ioutil.TempDir("", "micro")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// default config
+	config, err := LoadConfig("", "")
+	should.NoError(err)
+	should.Equal(DefaultConfig(), config)
+
+	// yaml file
+	yamlFile := filepath.Join(dir, "config.yaml")
+	should.NoError(ioutil.WriteFile(yamlFile, []byte(`
+http_port: 8080
+shutdown_timeout: 10s
+method_timeouts:
+  /test.Service/*: 2s
+redoc:
+  up: true
+  spec_urls:
+    Demo: /demo.swagger.json
+server:
+  max_recv_msg_size: 1024
+`), 0600))
+
+	config, err = LoadConfig(yamlFile, "")
+	should.NoError(err)
+	should.Equal(uint(8080), config.HTTPPort)
+	should.Equal(uint(9999), config.GRPCPort)
+	should.Equal(10*time.Second, config.ShutdownTimeout)
+	should.Equal(defaultPreShutdownDelay, config.PreShutdownDelay)
+	should.Equal(2*time.Second, config.MethodTimeouts["/test.Service/*"])
+	should.True(config.Redoc.Up)
+	should.Equal("/demo.swagger.json", config.Redoc.SpecURLs["Demo"])
+	should.Equal(1024, config.Server.MaxRecvMsgSize)
+	should.Equal(DefaultServerConfig().KeepaliveTime, config.Server.KeepaliveTime)
+
+	// json file
+	jsonFile := filepath.Join(dir, "config.json")
+	should.NoError(ioutil.WriteFile(jsonFile, []byte(`{"grpc_port": 9090, "static_dir": "/var/www", "server": {"idle_timeout": "1m"}}`), 0600))
+
+	config, err = LoadConfig(jsonFile, "")
+	should.NoError(err)
+	should.Equal(uint(9090), config.GRPCPort)
+	should.Equal("/var/www", config.StaticDir)
+	should.Equal(time.Minute, config.Server.IdleTimeout)
+
+	// environment overrides the file
+	os.Setenv("MICRO_TEST_HTTP_PORT", "8081")
+	os.Setenv("MICRO_TEST_PRE_SHUTDOWN_DELAY", "2s")
+	os.Setenv("MICRO_TEST_STATIC_DIR", "/srv: static")
+	os.Setenv("MICRO_TEST_REDOC_SPEC_URLS", "{Service: /swagger.json}")
+	os.Setenv("MICRO_TEST_SERVER_KEEPALIVE_PERMIT_WITHOUT_STREAM", "false")
+	defer func() {
+		for _, name := range []string{"HTTP_PORT", "PRE_SHUTDOWN_DELAY", "STATIC_DIR", "REDOC_SPEC_URLS", "SERVER_KEEPALIVE_PERMIT_WITHOUT_STREAM"} {
+			os.Unsetenv("MICRO_TEST_" + name)
+		}
+	}()
+
+	config, err = LoadConfig(yamlFile, "micro_test")
+	should.NoError(err)
+	should.Equal(uint(8081), config.HTTPPort)
+	should.Equal(2*time.Second, config.PreShutdownDelay)
+	should.Equal("/srv: static", config.StaticDir)
+	should.Equal(map[string]string{"Demo": "/demo.swagger.json", "Service": "/swagger.json"}, config.Redoc.SpecURLs)
+	should.False(config.Server.KeepalivePermitWithoutStream)
+
+	os.Setenv("MICRO_TEST_HTTP_PORT", "http")
+	_, err = LoadConfig(yamlFile, "MICRO_TEST")
+	should.Error(err)
+	should.Contains(err.Error(), "invalid MICRO_TEST_HTTP_PORT")
+
+	// unknown fields and invalid values
+	should.NoError(ioutil.WriteFile(yamlFile, []byte("http_prot: 8080\n"), 0600))
+	_, err = LoadConfig(yamlFile, "")
+	should.Error(err)
+
+	should.NoError(ioutil.WriteFile(yamlFile, []byte("grpc_port: 8888\n"), 0600))
+	_, err = LoadConfig(yamlFile, "")
+	should.EqualError(err, "http_port and grpc_port must be different")
+
+	_, err = LoadConfig(filepath.Join(dir, "missing.yaml"), "")
+	should.Error(err)
+}
+
+func TestConfigValidate(t *testing.T) {
+	var should = require.New(t)
+
+	config := DefaultConfig()
+	config.HTTPPort = 0
+	should.EqualError(config.Validate(), "invalid http_port: 0")
+
+	config = DefaultConfig()
+	config.MethodTimeouts = map[string]time.Duration{"/test.Service/Method": -time.Second}
+	should.EqualError(config.Validate(), "method_timeouts: timeout of /test.Service/Method must not be negative")
+
+	config = DefaultConfig()
+	config.Redoc.Route = "docs"
+	should.EqualError(config.Validate(), "redoc: route should start with /: docs")
+
+	config = DefaultConfig()
+	config.TLS.CertFile = "server.crt"
+	should.EqualError(config.Validate(), "tls: cert_file and key_file must be set together")
+
+	config = DefaultConfig()
+	config.TLS = TLSFiles{CertFile: "server.crt", KeyFile: "server.key", ClientAuth: true}
+	should.EqualError(config.Validate(), "tls: client_auth requires ca_file")
+
+	config = DefaultConfig()
+	config.Server.MaxRecvMsgSize = 0
+	should.EqualError(config.Validate(), "server: max_recv_msg_size must be positive")
+}
+
+func TestConfigOptions(t *testing.T) {
+	var should = require.New(t)
+
+	config := DefaultConfig()
+	config.ShutdownTimeout = 5 * time.Second
+	config.DefaultTimeout = time.Second
+	config.Redoc.Up = true
+
+	opts, err := config.Options()
+	should.NoError(err)
+
+	s := NewService(opts...)
+	should.Equal(5*time.Second, s.shutdownTimeout)
+	should.Equal(time.Second, s.defaultTimeout)
+	should.True(s.redoc.Up)
+	should.Nil(s.tlsConfig)
+
+	// tls files
+	dir, err := ioutil.TempDir("", "micro")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+
+	certs, err := NewDevCertificates()
+	should.NoError(err)
+	config.TLS.CertFile, config.TLS.KeyFile = writeDevCertificate(t, dir, "server", certs.Server)
+	config.TLS.ClientCertFile, config.TLS.ClientKeyFile = writeDevCertificate(t, dir, "client", certs.Client)
+	config.TLS.CAFile = filepath.Join(dir, "ca.crt")
+	config.TLS.ClientAuth = true
+	should.NoError(ioutil.WriteFile(config.TLS.CAFile, certs.CAPEM, 0600))
+
+	server, client, err := config.TLS.Load()
+	should.NoError(err)
+	should.Len(server.Certificates, 1)
+	should.Equal(tls.RequireAndVerifyClientCert, server.ClientAuth)
+	should.NotNil(client.RootCAs)
+	should.Len(client.Certificates, 1)
+
+	opts, err = config.Options()
+	should.NoError(err)
+	s = NewService(opts...)
+	should.NotNil(s.tlsConfig)
+	should.Equal(s.tlsConfig, s.HTTPServer.TLSConfig)
+
+	config.TLS.KeyFile = config.TLS.ClientKeyFile
+	_, err = config.Options()
+	should.Error(err)
+}
@@ -0,0 +1,545 @@
+package micro
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	// the content types of Connect unary and streaming calls
+	connectUnaryJSONContentType  = "application/json"
+	connectUnaryProtoContentType = "application/proto"
+	connectJSONContentType       = "application/connect+json"
+	connectProtoContentType      = "application/connect+proto"
+	// the flags of the envelopes of Connect streaming calls
+	connectCompressedFlag = 0x01
+	connectEndStreamFlag  = 0x02
+)
+
+// connectCode is the Connect code and the http status of a gRPC code
+type connectCode struct {
+	name   string
+	status int
+}
+
+var connectCodes = map[codes.Code]connectCode{
+	codes.Canceled:           {"canceled", 499},
+	codes.Unknown:            {"unknown", http.StatusInternalServerError},
+	codes.InvalidArgument:    {"invalid_argument", http.StatusBadRequest},
+	codes.DeadlineExceeded:   {"deadline_exceeded", http.StatusGatewayTimeout},
+	codes.NotFound:           {"not_found", http.StatusNotFound},
+	codes.AlreadyExists:      {"already_exists", http.StatusConflict},
+	codes.PermissionDenied:   {"permission_denied", http.StatusForbidden},
+	codes.ResourceExhausted:  {"resource_exhausted", http.StatusTooManyRequests},
+	codes.FailedPrecondition: {"failed_precondition", http.StatusBadRequest},
+	codes.Aborted:            {"aborted", http.StatusConflict},
+	codes.OutOfRange:         {"out_of_range", http.StatusBadRequest},
+	codes.Unimplemented:      {"unimplemented", http.StatusNotImplemented},
+	codes.Internal:           {"internal", http.StatusInternalServerError},
+	codes.Unavailable:        {"unavailable", http.StatusServiceUnavailable},
+	codes.DataLoss:           {"data_loss", http.StatusInternalServerError},
+	codes.Unauthenticated:    {"unauthenticated", http.StatusUnauthorized},
+}
+
+// connectError is the error in Connect JSON format
+type connectError struct {
+	Code    string               `json:"code"`
+	Message string               `json:"message,omitempty"`
+	Details []connectErrorDetail `json:"details,omitempty"`
+}
+
+// connectErrorDetail is the detail of the error, the value is the base64 encoded protobuf message
+type connectErrorDetail struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// connectEndStream is the last message of Connect streaming responses
+type connectEndStream struct {
+	Error    *connectError `json:"error,omitempty"`
+	Metadata http.Header   `json:"metadata,omitempty"`
+}
+
+// newConnectError converts the status into Connect JSON format, it returns nil if the status is OK
+func newConnectError(st *status.Status) *connectError {
+	if st.Code() == codes.OK {
+		return nil
+	}
+
+	code, ok := connectCodes[st.Code()]
+	if !ok {
+		code = connectCodes[codes.Unknown]
+	}
+
+	e := &connectError{Code: code.name, Message: st.Message()}
+	for _, detail := range st.Proto().Details {
+		typeURL := detail.TypeUrl
+		e.Details = append(e.Details, connectErrorDetail{
+			Type:  typeURL[strings.LastIndex(typeURL, "/")+1:],
+			Value: base64.RawStdEncoding.EncodeToString(detail.Value),
+		})
+	}
+
+	return e
+}
+
+// writeConnectError writes the error of the unary call or the call not served
+func writeConnectError(w http.ResponseWriter, st *status.Status) {
+	code, ok := connectCodes[st.Code()]
+	if !ok {
+		code = connectCodes[codes.Unknown]
+	}
+
+	w.Header().Set("Content-Type", connectUnaryJSONContentType)
+	w.WriteHeader(code.status)
+	json.NewEncoder(w).Encode(newConnectError(st))
+}
+
+// rejectConnect responds unavailable on shutdown
+func rejectConnect(w http.ResponseWriter) {
+	writeConnectError(w, status.New(codes.Unavailable, "service is shutting down"))
+}
+
+// connectMethods returns the methods of the services registered on the gRPC server by their paths, the
+// services without the descriptors in the global registry are ignored
+func connectMethods(server *grpc.Server) map[string]protoreflect.MethodDescriptor {
+	methods := make(map[string]protoreflect.MethodDescriptor)
+	for name, info := range server.GetServiceInfo() {
+		desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			continue
+		}
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			continue
+		}
+
+		for _, m := range info.Methods {
+			if md := sd.Methods().ByName(protoreflect.Name(m.Name)); md != nil {
+				methods["/"+name+"/"+m.Name] = md
+			}
+		}
+	}
+
+	return methods
+}
+
+// isConnectRequest checks if the request calls a method registered on the gRPC server
+func (s *Service) isConnectRequest(r *http.Request) bool {
+	_, ok := s.connectMethods[r.URL.Path]
+	return ok && r.Method == http.MethodPost
+}
+
+// serveConnect translates the Connect call to /package.Service/Method in JSON or protobuf into the gRPC call
+// served by the gRPC server over http, the JSON messages are converted by the descriptors of the method in the
+// protobuf registry. The errors are responded in Connect JSON format, the streaming calls are limited by the
+// WriteTimeout of the ServerConfig and cancelled on shutdown like the gRPC-Web calls
+func (s *Service) serveConnect(w http.ResponseWriter, r *http.Request) {
+	md := s.connectMethods[r.URL.Path]
+	streaming := md.IsStreamingClient() || md.IsStreamingServer()
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var useJSON bool
+	switch {
+	case !streaming && contentType == connectUnaryJSONContentType, streaming && contentType == connectJSONContentType:
+		useJSON = true
+	case !streaming && contentType == connectUnaryProtoContentType, streaming && contentType == connectProtoContentType:
+	default:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	for _, name := range []string{"Content-Encoding", "Connect-Content-Encoding"} {
+		if encoding := r.Header.Get(name); encoding != "" && encoding != "identity" {
+			writeConnectError(w, status.Newf(codes.Unimplemented, "unsupported compression: %s", encoding))
+			return
+		}
+	}
+
+	req := disguiseHTTP2(r, grpcContentType+"+proto")
+	if timeout := r.Header.Get("Connect-Timeout-Ms"); timeout != "" {
+		ms, err := strconv.ParseInt(timeout, 10, 64)
+		if err != nil || ms < 0 {
+			writeConnectError(w, status.Newf(codes.InvalidArgument, "invalid timeout: %s", timeout))
+			return
+		}
+		req.Header.Set("Grpc-Timeout", grpcTimeout(ms))
+	}
+	for name := range req.Header {
+		if strings.HasPrefix(name, "Connect-") {
+			req.Header.Del(name)
+		}
+	}
+
+	var rejected <-chan error
+	if streaming {
+		req.Body, rejected = connectStreamBody(r.Body, md.Input(), useJSON, s.serverConfig.MaxRecvMsgSize)
+	} else {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeConnectError(w, status.Newf(codes.InvalidArgument, "failed to read request: %v", err))
+			return
+		}
+		if useJSON {
+			if body, err = connectJSONToProto(md.Input(), body); err != nil {
+				writeConnectError(w, status.Newf(codes.InvalidArgument, "invalid request: %v", err))
+				return
+			}
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(grpcFrame(0, body)))
+	}
+
+	cw := &connectResponseWriter{
+		w:         w,
+		header:    newGRPCResponseHeader(),
+		output:    md.Output(),
+		json:      useJSON,
+		streaming: streaming,
+	}
+	s.GRPCServer.ServeHTTP(cw, req)
+	select {
+	case err := <-rejected:
+		cw.rejected = err
+	default:
+	}
+	cw.finish(req.Context())
+}
+
+// grpcTimeout formats the timeout in milliseconds as grpc-timeout which has at most 8 digits
+func grpcTimeout(ms int64) string {
+	if ms < 1e8 {
+		return strconv.FormatInt(ms, 10) + "m"
+	}
+
+	return strconv.FormatInt(ms/1000, 10) + "S"
+}
+
+// grpcFrame encodes the data as the frame of gRPC and Connect streaming
+func grpcFrame(flag byte, data []byte) []byte {
+	frame := make([]byte, 5, 5+len(data))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+
+	return append(frame, data...)
+}
+
+// connectStreamBody converts the envelopes of the Connect streaming request into the gRPC frames, the messages
+// larger than maxSize are rejected before they are allocated and the status is sent to the returned channel
+// before the body fails since the gRPC server reports the failure of the body as unavailable
+func connectStreamBody(body io.Reader, input protoreflect.MessageDescriptor, useJSON bool, maxSize int) (io.ReadCloser, <-chan error) {
+	pr, pw := io.Pipe()
+	rejected := make(chan error, 1)
+	go func() {
+		header := make([]byte, 5)
+		for {
+			if _, err := io.ReadFull(body, header); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				pw.CloseWithError(err)
+				return
+			}
+			if header[0]&connectCompressedFlag != 0 {
+				pw.CloseWithError(errors.New("compressed messages are not supported"))
+				return
+			}
+
+			size := binary.BigEndian.Uint32(header[1:])
+			if int64(size) > int64(maxSize) {
+				err := status.Errorf(codes.ResourceExhausted, "message is larger than %d bytes", maxSize)
+				rejected <- err
+				pw.CloseWithError(err)
+				return
+			}
+
+			data := make([]byte, size)
+			if _, err := io.ReadFull(body, data); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if useJSON {
+				var err error
+				if data, err = connectJSONToProto(input, data); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+
+			if _, err := pw.Write(grpcFrame(0, data)); err != nil {
+				return
+			}
+		}
+	}()
+
+	return pr, rejected
+}
+
+// connectJSONToProto converts the JSON message into the protobuf wire format
+func connectJSONToProto(desc protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(desc)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(msg)
+}
+
+// connectProtoToJSON converts the message in the protobuf wire format into JSON
+func connectProtoToJSON(desc protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+
+	return protojson.Marshal(msg)
+}
+
+// grpcStatusFromTrailers returns the status in the trailers of the gRPC response
+func grpcStatusFromTrailers(trailers http.Header) *status.Status {
+	values := trailers["grpc-status"]
+	if len(values) == 0 {
+		return status.New(codes.Internal, "missing grpc-status")
+	}
+	code, err := strconv.Atoi(values[0])
+	if err != nil {
+		return status.Newf(codes.Internal, "invalid grpc-status: %s", values[0])
+	}
+
+	if values := trailers["grpc-status-details-bin"]; len(values) > 0 {
+		data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(values[0], "="))
+		if err == nil {
+			var p spb.Status
+			if proto.Unmarshal(data, &p) == nil {
+				return status.FromProto(&p)
+			}
+		}
+	}
+
+	var message string
+	if values := trailers["grpc-message"]; len(values) > 0 {
+		message = values[0]
+		if unescaped, err := url.PathUnescape(message); err == nil {
+			message = unescaped
+		}
+	}
+
+	return status.New(codes.Code(code), message)
+}
+
+// connectResponseWriter converts the gRPC response into Connect, the unary response is buffered to respond
+// the status in the http status and the trailers in the headers prefixed by Trailer-
+type connectResponseWriter struct {
+	w           http.ResponseWriter
+	header      *grpcResponseHeader
+	output      protoreflect.MessageDescriptor
+	json        bool
+	streaming   bool
+	wroteHeader bool
+	// the response is not a gRPC response if the request is rejected by the gRPC server
+	passthrough bool
+	// the headers of the gRPC response
+	headers    http.Header
+	sentHeader bool
+	// the bytes of the incomplete frame
+	buf []byte
+	// the message of the unary response, it may be empty once received
+	message  []byte
+	received bool
+	err      error
+	// the error of the request which fails the call whatever the status returned by the method
+	rejected error
+}
+
+// Header implements http.ResponseWriter interface
+func (cw *connectResponseWriter) Header() http.Header {
+	return cw.header.header
+}
+
+// WriteHeader implements http.ResponseWriter interface, the headers are kept until the first message of
+// streaming responses or the end of unary responses
+func (cw *connectResponseWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	headers := cw.header.split()
+	if !strings.HasPrefix(headers.Get("Content-Type"), grpcContentType) {
+		cw.passthrough = true
+		for k, v := range headers {
+			cw.w.Header()[k] = v
+		}
+		cw.w.WriteHeader(code)
+		return
+	}
+
+	for _, name := range []string{"Content-Type", "Grpc-Encoding", "Grpc-Accept-Encoding"} {
+		headers.Del(name)
+	}
+	cw.headers = headers
+}
+
+// Write implements http.ResponseWriter interface, the gRPC frames are converted once complete
+func (cw *connectResponseWriter) Write(b []byte) (int, error) {
+	cw.WriteHeader(http.StatusOK)
+	if cw.passthrough {
+		return cw.w.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	for len(cw.buf) >= 5 {
+		size := int(binary.BigEndian.Uint32(cw.buf[1:]))
+		if len(cw.buf) < 5+size {
+			break
+		}
+		cw.writeMessage(cw.buf[0], cw.buf[5:5+size])
+		cw.buf = cw.buf[5+size:]
+	}
+
+	return len(b), nil
+}
+
+// writeMessage converts the message, the following messages are dropped once it fails
+func (cw *connectResponseWriter) writeMessage(flag byte, data []byte) {
+	if cw.err != nil {
+		return
+	}
+	if flag != 0 {
+		cw.err = status.Error(codes.Internal, "compressed messages are not supported")
+		return
+	}
+
+	if cw.json {
+		var err error
+		if data, err = connectProtoToJSON(cw.output, data); err != nil {
+			cw.err = status.Errorf(codes.Internal, "failed to marshal response: %v", err)
+			return
+		}
+	}
+
+	if !cw.streaming {
+		cw.message = append([]byte(nil), data...)
+		cw.received = true
+		return
+	}
+
+	cw.sendHeader()
+	cw.w.Write(grpcFrame(0, data))
+}
+
+// sendHeader sends the headers of the streaming response once
+func (cw *connectResponseWriter) sendHeader() {
+	if cw.sentHeader {
+		return
+	}
+	cw.sentHeader = true
+
+	header := cw.w.Header()
+	for k, v := range cw.headers {
+		header[k] = v
+	}
+	if cw.json {
+		header.Set("Content-Type", connectJSONContentType)
+	} else {
+		header.Set("Content-Type", connectProtoContentType)
+	}
+	cw.w.WriteHeader(http.StatusOK)
+}
+
+// Flush implements http.Flusher interface which is required by the gRPC server
+func (cw *connectResponseWriter) Flush() {
+	cw.WriteHeader(http.StatusOK)
+	if cw.passthrough || cw.streaming {
+		if !cw.passthrough {
+			cw.sendHeader()
+		}
+		if f, ok := cw.w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// finish writes the end of the response with the status and the trailers, the status may be missing if the
+// call is cancelled
+func (cw *connectResponseWriter) finish(ctx context.Context) {
+	if cw.passthrough {
+		return
+	}
+
+	trailers := cw.header.trailers()
+	st := grpcStatusFromTrailers(trailers)
+	if _, ok := trailers["grpc-status"]; !ok && ctx.Err() != nil {
+		st = status.FromContextError(ctx.Err())
+	}
+	if cw.err != nil && st.Code() == codes.OK {
+		st, _ = status.FromError(cw.err)
+	}
+	if cw.rejected != nil {
+		st, _ = status.FromError(cw.rejected)
+	}
+	for _, name := range []string{"grpc-status", "grpc-message", "grpc-status-details-bin"} {
+		delete(trailers, name)
+	}
+
+	if cw.streaming {
+		cw.sendHeader()
+		end := connectEndStream{Error: newConnectError(st)}
+		if len(trailers) > 0 {
+			end.Metadata = trailers
+		}
+		data, _ := json.Marshal(end)
+		cw.w.Write(grpcFrame(connectEndStreamFlag, data))
+		if f, ok := cw.w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return
+	}
+
+	header := cw.w.Header()
+	for k, v := range cw.headers {
+		header[k] = v
+	}
+	for k, v := range trailers {
+		header[http.CanonicalHeaderKey("Trailer-"+k)] = v
+	}
+
+	if st.Code() != codes.OK {
+		writeConnectError(cw.w, st)
+		return
+	}
+	if !cw.received {
+		writeConnectError(cw.w, status.New(codes.Internal, "missing response message"))
+		return
+	}
+
+	if cw.json {
+		header.Set("Content-Type", connectUnaryJSONContentType)
+	} else {
+		header.Set("Content-Type", connectUnaryProtoContentType)
+	}
+	header.Set("Content-Length", fmt.Sprint(len(cw.message)))
+	cw.w.WriteHeader(http.StatusOK)
+	cw.w.Write(cw.message)
+}
@@ -0,0 +1,172 @@
+package micro
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestConnect(t *testing.T) {
+	var should = require.New(t)
+
+	healthServer := health.NewServer()
+	s := NewService(Connect(), Health(healthServer), PreShutdownDelay(0))
+	go s.Start(20888, 20999, noopReverseProxyFunc)
+	defer s.Stop()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	call := func(path, contentType string, body []byte) (*http.Response, []byte) {
+		req, err := http.NewRequest("POST", "http://127.0.0.1:20888"+path, bytes.NewReader(body))
+		should.NoError(err)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Connect-Protocol-Version", "1")
+		req.Header.Set("Connect-Timeout-Ms", "5000")
+
+		resp, err := http.DefaultClient.Do(req)
+		should.NoError(err)
+		defer resp.Body.Close()
+		respBody, err := ioutil.ReadAll(resp.Body)
+		should.NoError(err)
+
+		return resp, respBody
+	}
+
+	// unary in JSON
+	resp, body := call("/grpc.health.v1.Health/Check", "application/json", []byte(`{}`))
+	should.Equal(http.StatusOK, resp.StatusCode)
+	should.Equal("application/json", resp.Header.Get("Content-Type"))
+	should.JSONEq(`{"status":"SERVING"}`, string(body))
+
+	// unary in protobuf
+	data, err := proto.Marshal(&healthpb.HealthCheckRequest{})
+	should.NoError(err)
+	resp, body = call("/grpc.health.v1.Health/Check", "application/proto", data)
+	should.Equal(http.StatusOK, resp.StatusCode)
+	should.Equal("application/proto", resp.Header.Get("Content-Type"))
+	var checkResp healthpb.HealthCheckResponse
+	should.NoError(proto.Unmarshal(body, &checkResp))
+	should.Equal(healthpb.HealthCheckResponse_SERVING, checkResp.Status)
+
+	// the empty message is a response as well
+	healthServer.SetServingStatus("empty", healthpb.HealthCheckResponse_UNKNOWN)
+	data, err = proto.Marshal(&healthpb.HealthCheckRequest{Service: "empty"})
+	should.NoError(err)
+	resp, body = call("/grpc.health.v1.Health/Check", "application/proto", data)
+	should.Equal(http.StatusOK, resp.StatusCode)
+	should.Equal("application/proto", resp.Header.Get("Content-Type"))
+	should.Empty(body)
+
+	// the error is responded in JSON with the http status
+	resp, body = call("/grpc.health.v1.Health/Check", "application/json", []byte(`{"service":"unknown"}`))
+	should.Equal(http.StatusNotFound, resp.StatusCode)
+	should.Equal("application/json", resp.Header.Get("Content-Type"))
+	should.JSONEq(`{"code":"not_found","message":"unknown service"}`, string(body))
+
+	resp, body = call("/grpc.health.v1.Health/Check", "application/json", []byte(`{"service":1}`))
+	should.Equal(http.StatusBadRequest, resp.StatusCode)
+	should.Contains(string(body), `"code":"invalid_argument"`)
+
+	// the content type of streaming calls is not allowed for unary calls
+	resp, _ = call("/grpc.health.v1.Health/Check", "application/connect+json", nil)
+	should.Equal(http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	// the unknown methods and other requests are passed to the gateway
+	resp, _ = call("/grpc.health.v1.Health/Unknown", "application/json", []byte(`{}`))
+	should.Equal(http.StatusNotFound, resp.StatusCode)
+	r, err := http.Get("http://127.0.0.1:20888/ready")
+	should.NoError(err)
+	r.Body.Close()
+	should.Equal(http.StatusOK, r.StatusCode)
+}
+
+func TestConnectServerStreaming(t *testing.T) {
+	var should = require.New(t)
+
+	healthServer := health.NewServer()
+	s := NewService(Connect(), Health(healthServer), PreShutdownDelay(0))
+	go s.Start(17888, 17999, noopReverseProxyFunc)
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", "http://127.0.0.1:17888/grpc.health.v1.Health/Watch", bytes.NewReader(grpcFrame(0, []byte(`{"service":"test"}`))))
+	should.NoError(err)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/connect+json")
+	resp, err := http.DefaultClient.Do(req)
+	should.NoError(err)
+	defer resp.Body.Close()
+	should.Equal(http.StatusOK, resp.StatusCode)
+	should.Equal("application/connect+json", resp.Header.Get("Content-Type"))
+
+	// each message is flushed once sent
+	reader := bufio.NewReader(resp.Body)
+	frame := readGRPCWebFrame(t, reader)
+	should.Equal(byte(0), frame.flag)
+	should.JSONEq(`{"status":"SERVICE_UNKNOWN"}`, string(frame.data))
+
+	healthServer.SetServingStatus("test", healthpb.HealthCheckResponse_SERVING)
+	frame = readGRPCWebFrame(t, reader)
+	should.JSONEq(`{"status":"SERVING"}`, string(frame.data))
+
+	// the stream in flight is ended with the error on shutdown after the status of NOT_SERVING
+	should.NoError(s.Shutdown(context.Background()))
+	for frame = readGRPCWebFrame(t, reader); frame.flag == 0; frame = readGRPCWebFrame(t, reader) {
+		should.JSONEq(`{"status":"NOT_SERVING"}`, string(frame.data))
+	}
+	should.Equal(byte(connectEndStreamFlag), frame.flag)
+	var end connectEndStream
+	should.NoError(json.Unmarshal(frame.data, &end))
+	should.NotNil(end.Error)
+	should.Equal("canceled", end.Error.Code)
+
+	// the new calls are rejected
+	_, ok := s.grpcHTTP.begin(context.Background())
+	should.False(ok)
+}
+
+func TestConnectMessageTooLarge(t *testing.T) {
+	var should = require.New(t)
+
+	config := DefaultServerConfig()
+	config.MaxRecvMsgSize = 64
+	s := NewService(Connect(), Health(health.NewServer()), WithServerConfig(config), PreShutdownDelay(0))
+	go s.Start(30888, 30999, noopReverseProxyFunc)
+	defer s.Stop()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	// the size of the envelope is rejected before the message is read
+	body := []byte{0, 0xff, 0xff, 0xff, 0xff}
+	req, err := http.NewRequest("POST", "http://127.0.0.1:30888/grpc.health.v1.Health/Watch", bytes.NewReader(body))
+	should.NoError(err)
+	req.Header.Set("Content-Type", "application/connect+json")
+	resp, err := http.DefaultClient.Do(req)
+	should.NoError(err)
+	defer resp.Body.Close()
+	should.Equal(http.StatusOK, resp.StatusCode)
+
+	frame := readGRPCWebFrame(t, bufio.NewReader(resp.Body))
+	should.Equal(byte(connectEndStreamFlag), frame.flag)
+	var end connectEndStream
+	should.NoError(json.Unmarshal(frame.data, &end))
+	should.NotNil(end.Error)
+	should.Equal("resource_exhausted", end.Error.Code)
+	should.Equal("message is larger than 64 bytes", end.Error.Message)
+}
@@ -0,0 +1,71 @@
+package micro
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// the methods allowed in the CORS preflight responses
+const corsAllowedMethods = "GET, HEAD, POST, PUT, PATCH, DELETE"
+
+// cors allows the cross-origin requests from the origins, the origins can be replaced at runtime
+type cors struct {
+	mu      sync.RWMutex
+	origins []string
+}
+
+func newCORS(origins []string) *cors {
+	c := &cors{}
+	c.update(origins)
+
+	return c
+}
+
+// update replaces the allowed origins
+func (c *cors) update(origins []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.origins = origins
+}
+
+// allowed checks if the origin is allowed, "*" allows any origin
+func (c *cors) allowed(origin string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, o := range c.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// middleware sets the CORS headers for the allowed origins and responds the preflight requests
+func (c *cors) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !c.allowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		header.Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				header.Set("Access-Control-Allow-Headers", headers)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
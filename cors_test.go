@@ -0,0 +1,51 @@
+package micro
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORS(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(CORS("https://example.org"))
+	handler := s.cors.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	// the allowed origin
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://example.org")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	should.Equal(http.StatusAccepted, recorder.Code)
+	should.Equal("https://example.org", recorder.Header().Get("Access-Control-Allow-Origin"))
+
+	// the preflight request
+	req = httptest.NewRequest("OPTIONS", "/", nil)
+	req.Header.Set("Origin", "https://example.org")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "Authorization")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	should.Equal(http.StatusNoContent, recorder.Code)
+	should.Equal(corsAllowedMethods, recorder.Header().Get("Access-Control-Allow-Methods"))
+	should.Equal("Authorization", recorder.Header().Get("Access-Control-Allow-Headers"))
+
+	// the other origin
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://other.org")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	should.Equal(http.StatusAccepted, recorder.Code)
+	should.Empty(recorder.Header().Get("Access-Control-Allow-Origin"))
+
+	// any origin
+	s.cors.update([]string{"*"})
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	should.Equal("https://other.org", recorder.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,59 @@
+package micro
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	var should = require.New(t)
+
+	for _, spec := range []string{
+		"* * * * *",
+		"*/5 0-6,22 1 jan-mar,dec mon-fri",
+		"5/10 * * * 7",
+		"@Daily",
+	} {
+		_, err := parseCron(spec)
+		should.NoError(err, spec)
+	}
+
+	for spec, message := range map[string]string{
+		"* * * *":     `invalid cron expression "* * * *": expected 5 fields but got 4`,
+		"60 * * * *":  `invalid cron expression "60 * * * *": invalid minute: 60`,
+		"* * 0 * *":   `invalid cron expression "* * 0 * *": invalid day of month: 0`,
+		"* 5-1 * * *": `invalid cron expression "* 5-1 * * *": invalid range of hour: 5-1`,
+		"*/0 * * * *": `invalid cron expression "*/0 * * * *": invalid step of minute: */0`,
+		"* * * foo *": `invalid cron expression "* * * foo *": invalid month: foo`,
+	} {
+		_, err := parseCron(spec)
+		should.EqualError(err, message, spec)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	var should = require.New(t)
+
+	// Friday
+	now := time.Date(2021, 4, 30, 23, 58, 30, 0, time.UTC)
+
+	for spec, next := range map[string]time.Time{
+		"* * * * *":       time.Date(2021, 4, 30, 23, 59, 0, 0, time.UTC),
+		"*/15 * * * *":    time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		"30 9 * * mon":    time.Date(2021, 5, 3, 9, 30, 0, 0, time.UTC),
+		"0 0 * * 7":       time.Date(2021, 5, 2, 0, 0, 0, 0, time.UTC),
+		"@monthly":        time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		"0 12 29 feb *":   time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC),
+		"0 0 13 * fri":    time.Date(2021, 5, 7, 0, 0, 0, 0, time.UTC),
+		"0 0 */10 * *":    time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 30 feb *":    {},
+		"58 23 30 4 *":    time.Date(2022, 4, 30, 23, 58, 0, 0, time.UTC),
+		"58-59 23 30 4 *": time.Date(2021, 4, 30, 23, 59, 0, 0, time.UTC),
+	} {
+		schedule, err := parseCron(spec)
+		should.NoError(err)
+		should.Equal(next, schedule.next(now), spec)
+	}
+}
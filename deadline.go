@@ -0,0 +1,68 @@
+package micro
+
+import (
+	"context"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// methodTimeout returns the server-side timeout of the method, the exact match takes precedence
+// over patterns and the default timeout is used if there is no override
+func (s *Service) methodTimeout(fullMethod string) time.Duration {
+	if key, ok := s.timeoutMethods.match(fullMethod); ok {
+		return s.methodTimeouts[key]
+	}
+
+	return s.defaultTimeout
+}
+
+// withDeadline applies the server-side timeout to the context unless the client sent an earlier deadline
+func (s *Service) withDeadline(ctx context.Context, fullMethod string) (context.Context, context.CancelFunc) {
+	timeout := s.methodTimeout(fullMethod)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// deadlineError turns the error caused by the exceeded deadline into DeadlineExceeded status, which
+// is mapped to 504 by the gateway
+func deadlineError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	return status.Error(codes.DeadlineExceeded, err.Error())
+}
+
+func (s *Service) deadlineUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, cancel := s.withDeadline(ctx, info.FullMethod)
+	defer cancel()
+
+	resp, err := handler(ctx, req)
+
+	return resp, deadlineError(ctx, err)
+}
+
+func (s *Service) deadlineStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, cancel := s.withDeadline(stream.Context(), info.FullMethod)
+	defer cancel()
+
+	wrapped := grpc_middleware.WrapServerStream(stream)
+	wrapped.WrappedContext = ctx
+
+	return deadlineError(ctx, handler(srv, wrapped))
+}
@@ -0,0 +1,109 @@
+package micro
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestMethodTimeout(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(
+		DefaultTimeout(time.Second),
+		MethodTimeout("/test.Service/*", 2*time.Second),
+		MethodTimeout("/test.Service/Slow", time.Minute),
+	)
+	should.Len(s.unaryInterceptors, 4)
+
+	should.Equal(time.Second, s.methodTimeout("/other.Service/Method"))
+	should.Equal(2*time.Second, s.methodTimeout("/test.Service/Method"))
+	should.Equal(time.Minute, s.methodTimeout("/test.Service/Slow"))
+}
+
+func TestDeadlineInterceptor(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(DefaultTimeout(50 * time.Millisecond))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+
+	// the default timeout is applied
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	_, err := s.deadlineUnaryInterceptor(context.Background(), nil, info, handler)
+	should.Equal(codes.DeadlineExceeded, status.Code(err))
+
+	// the earlier deadline of the client is kept
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	clientDeadline, _ := ctx.Deadline()
+	handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+		deadline, _ := ctx.Deadline()
+		should.Equal(clientDeadline, deadline)
+		return nil, nil
+	}
+	_, err = s.deadlineUnaryInterceptor(ctx, nil, info, handler)
+	should.NoError(err)
+
+	// the status returned by the handler is kept
+	handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, status.Error(codes.Aborted, "aborted")
+	}
+	_, err = s.deadlineUnaryInterceptor(context.Background(), nil, info, handler)
+	should.Equal(codes.Aborted, status.Code(err))
+}
+
+func TestDeadlineExceededGateway(t *testing.T) {
+	var should = require.New(t)
+
+	// the unknown methods block until the deadline
+	s := NewService(
+		DefaultTimeout(100*time.Millisecond),
+		PreShutdownDelay(0),
+		GRPCServerOption(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+			<-stream.Context().Done()
+			return stream.Context().Err()
+		})),
+	)
+
+	// the route calls the gRPC method like the generated gateway handlers
+	reverseProxyFunc := func(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error {
+		conn, err := grpc.Dial(grpcHostAndPort, opts...)
+		if err != nil {
+			return err
+		}
+
+		return mux.HandlePath("GET", "/v1/slow", func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			_, outboundMarshaler := runtime.MarshalerForRequest(mux, r)
+			err := conn.Invoke(r.Context(), "/test.Slow/Wait", &emptypb.Empty{}, &emptypb.Empty{})
+			if err != nil {
+				runtime.HTTPError(r.Context(), mux, outboundMarshaler, w, r, err)
+				return
+			}
+			outboundMarshaler.NewEncoder(w).Encode(&emptypb.Empty{})
+		})
+	}
+	go s.Start(12888, 12999, reverseProxyFunc)
+	defer s.Stop()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	start := time.Now()
+	resp, err := http.Get("http://127.0.0.1:12888/v1/slow")
+	should.NoError(err)
+	resp.Body.Close()
+	should.Equal(http.StatusGatewayTimeout, resp.StatusCode)
+	should.True(time.Since(start) < 5*time.Second)
+}
@@ -0,0 +1,165 @@
+package micro
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"time"
+)
+
+// the validity of the generated development certificates
+const devCertValidity = 24 * time.Hour
+
+// DevCertificates are the in-memory certificates generated for development and test, they are signed
+// by a freshly generated CA and must never be used in production
+type DevCertificates struct {
+	// CA is the certificate authority which signs the server and client certificates
+	CA *x509.Certificate
+	// CAPEM is the PEM encoded CA certificate
+	CAPEM []byte
+	// CertPool is the pool containing the CA
+	CertPool *x509.CertPool
+	// Server is the server certificate with the hosts as SANs
+	Server tls.Certificate
+	// Client is the client certificate for mutual tls
+	Client tls.Certificate
+}
+
+// NewDevCertificates generates a CA plus server and client certificates, hosts are the DNS names or
+// IP addresses of the server certificate, default to localhost, 127.0.0.1 and ::1
+func NewDevCertificates(hosts ...string) (*DevCertificates, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	caTemplate, err := devCertTemplate("micro development CA")
+	if err != nil {
+		return nil, err
+	}
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := &DevCertificates{
+		CA:       ca,
+		CAPEM:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		CertPool: x509.NewCertPool(),
+	}
+	certs.CertPool.AddCert(ca)
+
+	serverTemplate, err := devCertTemplate(hosts[0])
+	if err != nil {
+		return nil, err
+	}
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		}
+	}
+
+	if certs.Server, err = devSignCertificate(serverTemplate, ca, caKey); err != nil {
+		return nil, err
+	}
+
+	clientTemplate, err := devCertTemplate("client")
+	if err != nil {
+		return nil, err
+	}
+	clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	clientTemplate.DNSNames = []string{"client"}
+	clientTemplate.URIs = []*url.URL{{Scheme: "spiffe", Host: "micro.local", Path: "/client"}}
+
+	if certs.Client, err = devSignCertificate(clientTemplate, ca, caKey); err != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
+
+// ServerTLSConfig returns the tls config for the servers, client certificates are required and
+// verified against the CA if mutual is true
+func (c *DevCertificates) ServerTLSConfig(mutual bool) *tls.Config {
+	config := &tls.Config{
+		Certificates: []tls.Certificate{c.Server},
+	}
+
+	if mutual {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = c.CertPool
+	}
+
+	return config
+}
+
+// ClientTLSConfig returns the tls config for the clients which trusts the CA and presents the client certificate
+func (c *DevCertificates) ClientTLSConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{c.Client},
+		RootCAs:      c.CertPool,
+	}
+}
+
+func devCertTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"micro"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(devCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, nil
+}
+
+func devSignCertificate(template, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
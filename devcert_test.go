@@ -0,0 +1,84 @@
+package micro
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestNewDevCertificates(t *testing.T) {
+	var should = require.New(t)
+
+	certs, err := NewDevCertificates()
+	should.NoError(err)
+	should.True(certs.CA.IsCA)
+	should.Contains(string(certs.CAPEM), "BEGIN CERTIFICATE")
+	should.Equal([]string{"localhost"}, certs.Server.Leaf.DNSNames)
+	should.Len(certs.Server.Leaf.IPAddresses, 2)
+	should.NoError(certs.Server.Leaf.CheckSignatureFrom(certs.CA))
+	should.Equal("spiffe://micro.local/client", IdentityFromCertificate(certs.Client.Leaf).SPIFFEID)
+
+	certs, err = NewDevCertificates("example.org")
+	should.NoError(err)
+	should.Equal([]string{"example.org"}, certs.Server.Leaf.DNSNames)
+}
+
+func TestMutualTLS(t *testing.T) {
+	var should = require.New(t)
+
+	certs, err := NewDevCertificates()
+	should.NoError(err)
+
+	identities := make(chan *Identity, 1)
+	s := NewService(
+		TLS(certs.ServerTLSConfig(true), certs.ClientTLSConfig()),
+		ClientIdentity(func(ctx context.Context, fullMethod string, id *Identity) error {
+			identities <- id
+			return nil
+		}),
+		PreShutdownDelay(0),
+	)
+	healthpb.RegisterHealthServer(s.GRPCServer, health.NewServer())
+
+	go s.Start(38888, 39999, func(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error {
+		return nil
+	})
+	defer s.Stop()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	// call gRPC with the client certificate
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "localhost:39999",
+		grpc.WithTransportCredentials(credentials.NewTLS(certs.ClientTLSConfig())),
+		grpc.WithBlock(),
+	)
+	should.NoError(err)
+	defer conn.Close()
+
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	should.NoError(err)
+	should.Equal("client", (<-identities).CommonName)
+
+	// call https with and without the client certificate
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: certs.ClientTLSConfig()}}
+	resp, err := client.Get(fmt.Sprintf("https://localhost:%d/metrics", 38888))
+	should.NoError(err)
+	should.Equal(http.StatusOK, resp.StatusCode)
+
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: certs.CertPool}}}
+	_, err = client.Get(fmt.Sprintf("https://localhost:%d/metrics", 38888))
+	should.Error(err)
+}
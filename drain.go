@@ -0,0 +1,152 @@
+package micro
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	// the route of the readiness endpoint
+	readinessPath = "/ready"
+	// the interval to check the in-flight requests while draining
+	drainPollInterval = 50 * time.Millisecond
+	// the interval to log the in-flight requests while draining
+	drainLogInterval = time.Second
+)
+
+// Ready checks if the service is ready to receive traffic, it is ready once Start is called and not
+// ready any more as soon as the shutdown starts
+func (s *Service) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// setReady flips the readiness of both the http endpoint and the gRPC health server
+func (s *Service) setReady(ready bool) {
+	if ready {
+		atomic.StoreInt32(&s.ready, 1)
+		if s.healthServer != nil {
+			s.healthServer.Resume()
+		}
+		return
+	}
+
+	atomic.StoreInt32(&s.ready, 0)
+	if s.healthServer != nil {
+		s.healthServer.Shutdown()
+	}
+}
+
+// readinessHandler responds 200 if the service is ready or 503 otherwise
+func (s *Service) readinessHandler(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !s.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ready"))
+}
+
+// inflight returns the numbers of the in-flight gRPC and http requests, the requests forwarded by the
+// gateway are counted by both
+func (s *Service) inflight() (int32, int32) {
+	return atomic.LoadInt32(&s.grpcInflight), atomic.LoadInt32(&s.httpInflight)
+}
+
+func (s *Service) trackInflight(counter *int32, server string, delta int32) {
+	atomic.AddInt32(counter, delta)
+	inflightRequestsGauge.WithLabelValues(s.name, server).Add(float64(delta))
+}
+
+func (s *Service) inflightUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	s.trackInflight(&s.grpcInflight, "grpc", 1)
+	defer s.trackInflight(&s.grpcInflight, "grpc", -1)
+
+	return handler(ctx, req)
+}
+
+func (s *Service) inflightStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	s.trackInflight(&s.grpcInflight, "grpc", 1)
+	defer s.trackInflight(&s.grpcInflight, "grpc", -1)
+
+	return handler(srv, stream)
+}
+
+// inflightMiddleware counts the in-flight http requests
+func (s *Service) inflightMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.trackInflight(&s.httpInflight, "http", 1)
+		defer s.trackInflight(&s.httpInflight, "http", -1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// drain marks the service not ready and closes the http keep-alives, then waits until there is no in-flight
+// request or the preShutdownDelay expires, so that the load balancers stop sending traffic before the servers
+// stop. The gRPC clients receive GOAWAY once no http request is in flight, since the gRPC server stops
+// accepting the connections of the gateway as well. It is sent after the drain if gRPC is served over http
+// as well since GracefulStop can not drain those calls
+func (s *Service) drain(ctx context.Context) {
+	s.setReady(false)
+
+	// disable keep-alives on existing connections
+	s.HTTPServer.SetKeepAlivesEnabled(false)
+
+	if s.preShutdownDelay <= 0 {
+		return
+	}
+
+	s.logger.Printf("Draining for at most %v before shutdown starts", s.preShutdownDelay)
+
+	timer := time.NewTimer(s.preShutdownDelay)
+	defer timer.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	lastLog := time.Now()
+	for {
+		grpcInflight, httpInflight := s.inflight()
+		if httpInflight == 0 && s.grpcHTTP == nil {
+			s.gracefulStopGRPC()
+		}
+		if grpcInflight == 0 && httpInflight == 0 {
+			s.logger.Printf("No request in flight, shutdown starts")
+			return
+		}
+
+		if time.Since(lastLog) >= drainLogInterval {
+			s.logger.Printf("Draining: %d gRPC and %d http requests in flight", grpcInflight, httpInflight)
+			lastLog = time.Now()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			s.logger.Printf("Drain timeout: %d gRPC and %d http requests in flight, shutdown starts", grpcInflight, httpInflight)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// gracefulStopGRPC stops the gRPC server gracefully in the background once, the returned channel is closed
+// once it stops
+func (s *Service) gracefulStopGRPC() <-chan struct{} {
+	s.grpcStopOnce.Do(func() {
+		s.grpcStopped = make(chan struct{})
+		go func() {
+			s.GRPCServer.GracefulStop()
+			close(s.grpcStopped)
+		}()
+	})
+
+	return s.grpcStopped
+}
@@ -0,0 +1,212 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestReadiness(t *testing.T) {
+	var should = require.New(t)
+
+	healthServer := health.NewServer()
+	s := NewService(Health(healthServer), PreShutdownDelay(0))
+	should.False(s.Ready())
+
+	go s.Start(58888, 59999, func(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error {
+		return nil
+	})
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+	should.True(s.Ready())
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d%s", 58888, readinessPath))
+	should.NoError(err)
+	resp.Body.Close()
+	should.Equal(http.StatusOK, resp.StatusCode)
+
+	// the gRPC health server is registered
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "localhost:59999", grpc.WithInsecure(), grpc.WithBlock())
+	should.NoError(err)
+	defer conn.Close()
+
+	check, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	should.NoError(err)
+	should.Equal(healthpb.HealthCheckResponse_SERVING, check.Status)
+
+	// not ready once the shutdown starts
+	should.NoError(s.Shutdown(context.Background()))
+	should.False(s.Ready())
+
+	check, err = healthServer.Check(ctx, &healthpb.HealthCheckRequest{})
+	should.NoError(err)
+	should.Equal(healthpb.HealthCheckResponse_NOT_SERVING, check.Status)
+}
+
+func TestDrain(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(PreShutdownDelay(5 * time.Second))
+
+	// the drain ends once the in-flight requests finish
+	s.trackInflight(&s.grpcInflight, "grpc", 1)
+	s.trackInflight(&s.httpInflight, "http", 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		s.trackInflight(&s.grpcInflight, "grpc", -1)
+		s.trackInflight(&s.httpInflight, "http", -1)
+	}()
+
+	start := time.Now()
+	s.drain(context.Background())
+	should.True(time.Since(start) < time.Second)
+	should.False(s.Ready())
+
+	// the drain ends when the delay expires
+	s = NewService(PreShutdownDelay(100 * time.Millisecond))
+	s.trackInflight(&s.httpInflight, "http", 1)
+
+	start = time.Now()
+	s.drain(context.Background())
+	should.True(time.Since(start) >= 100*time.Millisecond)
+
+	grpcInflight, httpInflight := s.inflight()
+	should.Equal(int32(0), grpcInflight)
+	should.Equal(int32(1), httpInflight)
+	s.trackInflight(&s.httpInflight, "http", -1)
+}
+
+func TestDrainGoAway(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(Health(health.NewServer()), PreShutdownDelay(5*time.Second))
+	go s.Start(11888, 11999, func(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error {
+		return nil
+	})
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "localhost:11999", grpc.WithInsecure(), grpc.WithBlock())
+	should.NoError(err)
+	defer conn.Close()
+
+	// the stream in flight keeps the drain waiting
+	streamCtx, cancelStream := context.WithCancel(ctx)
+	stream, err := healthpb.NewHealthClient(conn).Watch(streamCtx, &healthpb.HealthCheckRequest{})
+	should.NoError(err)
+	_, err = stream.Recv()
+	should.NoError(err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+
+	// the client receives GOAWAY as soon as the drain starts
+	stateCtx, cancelState := context.WithTimeout(ctx, time.Second)
+	defer cancelState()
+	should.True(conn.WaitForStateChange(stateCtx, connectivity.Ready))
+	select {
+	case <-done:
+		should.Fail("the drain should wait for the stream")
+	default:
+	}
+
+	cancelStream()
+	should.NoError(<-done)
+}
+
+func TestDrainGateway(t *testing.T) {
+	var should = require.New(t)
+
+	release := make(chan struct{})
+	s := NewService(
+		Health(health.NewServer()),
+		PreShutdownDelay(5*time.Second),
+		RouteOpt(Route{
+			Method: "GET",
+			Path:   "/block",
+			Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+				<-release
+			},
+		}),
+	)
+
+	// the route calls the gRPC method like the generated gateway handlers
+	reverseProxyFunc := func(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error {
+		conn, err := grpc.Dial(grpcHostAndPort, opts...)
+		if err != nil {
+			return err
+		}
+
+		return mux.HandlePath("GET", "/v1/check", func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			_, outboundMarshaler := runtime.MarshalerForRequest(mux, r)
+			resp, err := healthpb.NewHealthClient(conn).Check(r.Context(), &healthpb.HealthCheckRequest{})
+			if err != nil {
+				runtime.HTTPError(r.Context(), mux, outboundMarshaler, w, r, err)
+				return
+			}
+			outboundMarshaler.NewEncoder(w).Encode(resp)
+		})
+	}
+	go s.Start(31888, 31999, reverseProxyFunc)
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	// the request in flight keeps the drain waiting
+	go http.Get("http://127.0.0.1:31888/block")
+	should.Eventually(func() bool {
+		_, httpInflight := s.inflight()
+		return httpInflight == 1
+	}, time.Second, 10*time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+	should.Eventually(func() bool { return !s.Ready() }, time.Second, 10*time.Millisecond)
+
+	// the gateway still serves the requests sent by the load balancers while draining
+	time.Sleep(100 * time.Millisecond)
+	resp, err := http.Get("http://127.0.0.1:31888/v1/check")
+	should.NoError(err)
+	resp.Body.Close()
+	should.Equal(http.StatusOK, resp.StatusCode)
+
+	close(release)
+	should.NoError(<-done)
+}
+
+func TestInflightMetrics(t *testing.T) {
+	var should = require.New(t)
+
+	// the services in the same process are labelled by their names
+	a := NewService(Name("metrics-a"))
+	b := NewService(Name("metrics-b"))
+	a.trackInflight(&a.httpInflight, "http", 1)
+	b.trackInflight(&b.httpInflight, "http", 1)
+	b.trackInflight(&b.httpInflight, "http", 1)
+	should.Equal(float64(1), testutil.ToFloat64(inflightRequestsGauge.WithLabelValues("metrics-a", "http")))
+	should.Equal(float64(2), testutil.ToFloat64(inflightRequestsGauge.WithLabelValues("metrics-b", "http")))
+
+	a.trackInflight(&a.httpInflight, "http", -1)
+	should.Equal(float64(0), testutil.ToFloat64(inflightRequestsGauge.WithLabelValues("metrics-a", "http")))
+	should.Equal(float64(2), testutil.ToFloat64(inflightRequestsGauge.WithLabelValues("metrics-b", "http")))
+}
@@ -0,0 +1,27 @@
+package micro
+
+import (
+	"strings"
+)
+
+// MultiError is the errors aggregated from the calls which do not stop at the first error
+type MultiError []error
+
+// Error implements error interface
+func (m MultiError) Error() string {
+	messages := make([]string, len(m))
+	for i, err := range m {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// errorOrNil returns nil if there is no error so that the nil MultiError is not returned as a non-nil error
+func (m MultiError) errorOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+
+	return m
+}
@@ -16,7 +16,6 @@ import (
 
 const (
 	serverName = "server"
-	serverCert = "certs/server.crt"
 	clientCert = "certs/client.crt"
 	clientKey  = "certs/client.key"
 	ca         = "certs/ca.crt"
@@ -43,9 +42,10 @@ func main() {
 	fmt.Println(res.GetMessage())
 
 	/***********************************************************************************************
-		Client 2: client connect to tls grpc server, note that our demo server's name is "server"
+		Client 2: client connect to tls grpc server, the certificates are generated by the demo server
+		on start and our demo server's name is "server"
 	***********************************************************************************************/
-	creds, err := credentials.NewClientTLSFromFile(serverCert, serverName)
+	creds, err := credentials.NewClientTLSFromFile(ca, serverName)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -10,3 +10,5 @@ require (
 	google.golang.org/grpc v1.37.0
 	google.golang.org/protobuf v1.25.1-0.20201208041424-160c7477e0e8
 )
+
+replace github.com/dakalab/micro/v2 => ../
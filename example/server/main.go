@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -33,12 +33,40 @@ var _ proto.GreeterServer = (*Greeter)(nil) // make sure it implements the inter
 
 var (
 	serverName = "server"
-	crt        = "certs/server.crt"
-	key        = "certs/server.key"
 	ca         = "certs/ca.crt"
+	clientCrt  = "certs/client.crt"
+	clientKey  = "certs/client.key"
 )
 
+// writeClientCerts writes the CA and the client certificate to disk so the demo client can connect
+func writeClientCerts(certs *micro.DevCertificates) error {
+	if err := ioutil.WriteFile(ca, certs.CAPEM, 0644); err != nil {
+		return err
+	}
+
+	crtPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certs.Client.Certificate[0]})
+	if err := ioutil.WriteFile(clientCrt, crtPEM, 0644); err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(certs.Client.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(clientKey, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
 func main() {
+	// generate the development certificates, the server certificate is valid for our demo server's name
+	certs, err := micro.NewDevCertificates(serverName, "localhost", "127.0.0.1", "::1")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := writeClientCerts(certs); err != nil {
+		log.Fatal(err)
+	}
 
 	reverseProxyFunc := func(
 		ctx context.Context,
@@ -85,15 +113,8 @@ func main() {
 		or credentials
 	************************************************************************************************/
 	// create the TLS credentials
-	serverCreds, err := credentials.NewServerTLSFromFile(crt, key)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	clientCreds, err := credentials.NewClientTLSFromFile(crt, serverName)
-	if err != nil {
-		log.Fatal(err)
-	}
+	serverCreds := credentials.NewTLS(certs.ServerTLSConfig(false))
+	clientCreds := credentials.NewClientTLSFromCert(certs.CertPool, serverName)
 
 	s2 := micro.NewService(
 		micro.RouteOpt(route),
@@ -108,36 +129,12 @@ func main() {
 	/***********************************************************************************************
 		Server 3: mutual tls server with certificate authority
 	************************************************************************************************/
-	// load the certificates from disk
-	certificate, err := tls.LoadX509KeyPair(crt, key)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// create the TLS configuration, client certificates are verified against the CA
+	serverCreds2 := credentials.NewTLS(certs.ServerTLSConfig(true))
 
-	// create a certificate pool from the certificate authority
-	certPool := x509.NewCertPool()
-	ca, err := ioutil.ReadFile(ca)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// append the client certificates from the CA
-	if ok := certPool.AppendCertsFromPEM(ca); !ok {
-		log.Fatal("failed to append ca certs")
-	}
-
-	// create the TLS configuration
-	serverCreds2 := credentials.NewTLS(&tls.Config{
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		Certificates: []tls.Certificate{certificate},
-		ClientCAs:    certPool,
-	})
-
-	clientCreds2 := credentials.NewTLS(&tls.Config{
-		ServerName:   serverName,
-		Certificates: []tls.Certificate{certificate},
-		RootCAs:      certPool,
-	})
+	clientConfig := certs.ClientTLSConfig()
+	clientConfig.ServerName = serverName
+	clientCreds2 := credentials.NewTLS(clientConfig)
 
 	s3 := micro.NewService(
 		micro.RouteOpt(route),
@@ -149,31 +146,20 @@ func main() {
 	)
 	proto.RegisterGreeterServer(s3.GRPCServer, &Greeter{})
 
-	errChan := make(chan error, 1)
+	// run all servers together, all of them are stopped gracefully if any of them fails
+	g := micro.NewGroup()
+	g.Logger = micro.LoggerFunc(log.Printf)
 
 	// run insecure server 1
-	go func() {
-		var httpPort, grpcPort uint
-		httpPort = 8888
-		grpcPort = 9999
-		errChan <- s.Start(httpPort, grpcPort, reverseProxyFunc)
-	}()
+	g.AddService("insecure server", s, 8888, 9999, reverseProxyFunc)
 
 	// run tls server 2
-	go func() {
-		var httpPort, grpcPort uint
-		httpPort = 18888
-		grpcPort = 19999
-		errChan <- s2.Start(httpPort, grpcPort, reverseProxyFunc)
-	}()
+	g.AddService("tls server", s2, 18888, 19999, reverseProxyFunc)
 
 	// run mutual tls server 3
-	go func() {
-		var httpPort, grpcPort uint
-		httpPort = 28888
-		grpcPort = 29999
-		errChan <- s3.Start(httpPort, grpcPort, reverseProxyFunc)
-	}()
-
-	log.Fatal(<-errChan)
+	g.AddService("mutual tls server", s3, 28888, 29999, reverseProxyFunc)
+
+	if err := g.Run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
 }
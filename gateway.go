@@ -0,0 +1,123 @@
+package micro
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// newGatewayKey generates the secret which is shared between the gateway and the gRPC server
+// of the same service, it is used to sign the metadata injected by the gateway so that the
+// gRPC server can tell it apart from the metadata sent by the clients
+func newGatewayKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+
+	return key
+}
+
+// signGateway signs the payload with the gateway key, the result is safe to be used as metadata value
+func (s *Service) signGateway(payload []byte) string {
+	mac := hmac.New(sha256.New, s.gatewayKey)
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyGateway verifies the value signed by signGateway and returns the payload
+func (s *Service) verifyGateway(value string) ([]byte, bool) {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, false
+	}
+
+	mac := hmac.New(sha256.New, s.gatewayKey)
+	mac.Write(payload)
+
+	return payload, hmac.Equal(sig, mac.Sum(nil))
+}
+
+// gatewayPayload returns the first payload of the metadata key which is signed by the gateway,
+// the values sent by the clients are ignored as they can not pass the verification
+func (s *Service) gatewayPayload(ctx context.Context, key string) ([]byte, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	for _, value := range md.Get(key) {
+		if payload, ok := s.verifyGateway(value); ok {
+			return payload, true
+		}
+	}
+
+	return nil, false
+}
+
+// clientIPMetadataKey is the metadata key carrying the client ip forwarded by the gateway
+const clientIPMetadataKey = "x-micro-client-ip"
+
+// clientIPAnnotator forwards the remote ip of the http client to gRPC metadata, unlike the
+// x-forwarded-for metadata it can not be forged by the clients
+func (s *Service) clientIPAnnotator(ctx context.Context, r *http.Request) metadata.MD {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+
+	return metadata.Pairs(clientIPMetadataKey, s.signGateway([]byte(host)))
+}
+
+// forwardClientIP installs the client ip annotator once
+func (s *Service) forwardClientIP() {
+	if !s.clientIPForwarded {
+		s.clientIPForwarded = true
+		s.annotators = append(s.annotators, s.clientIPAnnotator)
+	}
+}
+
+// fromGateway checks if the gRPC request is forwarded by the gateway, it requires forwardClientIP
+func (s *Service) fromGateway(ctx context.Context) bool {
+	_, ok := s.gatewayPayload(ctx, clientIPMetadataKey)
+	return ok
+}
+
+// clientIP returns the ip forwarded by the gateway or the one of the gRPC peer
+func (s *Service) clientIP(ctx context.Context) string {
+	if payload, ok := s.gatewayPayload(ctx, clientIPMetadataKey); ok {
+		return string(payload)
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
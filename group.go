@@ -0,0 +1,168 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// Runnable is the background worker run by Group, Run should return once the ctx is done
+type Runnable interface {
+	Run(ctx context.Context) error
+}
+
+// RunnableFunc is a bridge between Runnable and the function
+type RunnableFunc func(ctx context.Context) error
+
+// Run implements Runnable interface
+func (f RunnableFunc) Run(ctx context.Context) error { return f(ctx) }
+
+// member is the service or runnable in the group
+type member struct {
+	name    string
+	service *Service
+	run     func(ctx context.Context) error
+}
+
+// Group runs several services and background workers together, all of them are stopped gracefully once
+// any of them fails, a service stops, a stop signal is received or the ctx of Run is done
+type Group struct {
+	// Logger logs the start and the stop of the members, it writes nothing by default
+	Logger Logger
+	// Signals are the signals to stop the group, defaults to InterruptSignals
+	Signals []os.Signal
+	// StopTimeout is the maximum time waiting for the members to return once the group stops, defaults to 30s
+	StopTimeout time.Duration
+
+	members []member
+}
+
+// NewGroup creates an empty group
+func NewGroup() *Group {
+	return &Group{
+		Logger:      dummyLogger,
+		Signals:     InterruptSignals,
+		StopTimeout: defaultShutdownTimeout,
+	}
+}
+
+// AddService adds the service to be started on the ports, the service is stopped by Shutdown. The name labels
+// the metrics of the service unless set by the Name option
+func (g *Group) AddService(name string, s *Service, httpPort uint, grpcPort uint, reverseProxyFunc ReverseProxyFunc) {
+	if s.name == "" {
+		s.name = name
+	}
+	g.members = append(g.members, member{
+		name:    name,
+		service: s,
+		run: func(ctx context.Context) error {
+			return s.Start(httpPort, grpcPort, reverseProxyFunc)
+		},
+	})
+}
+
+// Add adds the background worker, the ctx passed to it is cancelled once the group stops. The group keeps
+// running if the worker returns nil before that
+func (g *Group) Add(name string, r Runnable) {
+	g.members = append(g.members, member{
+		name: name,
+		run:  r.Run,
+	})
+}
+
+// memberResult is the returned error of a member
+type memberResult struct {
+	member member
+	err    error
+}
+
+// Run starts all the members and blocks until all of them return, the errors of the members are aggregated
+// in a MultiError. The errors returned by the members while the group stops are ignored if they are caused
+// by the stop, i.e. the error of the ctx, context.Canceled and ErrServiceStopped
+func (g *Group) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	if len(g.Signals) > 0 {
+		signal.Notify(sigChan, g.Signals...)
+		defer signal.Stop(sigChan)
+	}
+
+	results := make(chan memberResult, len(g.members))
+	for _, m := range g.members {
+		g.Logger.Printf("Starting %s", m.name)
+		go func(m member) {
+			results <- memberResult{member: m, err: m.run(ctx)}
+		}(m)
+	}
+
+	var errs MultiError
+	running := len(g.members)
+	stopping := false
+
+	// stop stops all the members once
+	stop := func(reason string) {
+		if stopping {
+			return
+		}
+		stopping = true
+		g.Logger.Printf("Stopping group: %s", reason)
+
+		cancel()
+		for _, m := range g.members {
+			if m.service != nil {
+				go m.service.Shutdown(context.Background())
+			}
+		}
+	}
+
+	// done is disabled once stopping since the ctx is cancelled by the stop
+	done := ctx.Done()
+	var timeout <-chan time.Time
+	for running > 0 {
+		select {
+		case result := <-results:
+			running--
+			if err := g.memberError(ctx, result, stopping); err != nil {
+				errs = append(errs, err)
+				stop(err.Error())
+			} else if result.member.service != nil {
+				stop(fmt.Sprintf("%s stopped", result.member.name))
+			} else {
+				g.Logger.Printf("%s finished", result.member.name)
+			}
+
+		case sig := <-sigChan:
+			stop(fmt.Sprintf("signal %v received", sig))
+
+		case <-done:
+			stop(ctx.Err().Error())
+
+		case <-timeout:
+			errs = append(errs, fmt.Errorf("%d members did not stop within %v", running, g.StopTimeout))
+			return errs
+		}
+
+		if stopping && timeout == nil {
+			done = nil
+			timer := time.NewTimer(g.StopTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+	}
+
+	return errs.errorOrNil()
+}
+
+// memberError returns the error of the member with its name, the errors caused by the stop are ignored
+func (g *Group) memberError(ctx context.Context, result memberResult, stopping bool) error {
+	err := result.err
+	if err == nil || (stopping && (err == ctx.Err() || err == context.Canceled || err == ErrServiceStopped)) {
+		return nil
+	}
+
+	return fmt.Errorf("%s: %v", result.member.name, err)
+}
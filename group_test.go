@@ -0,0 +1,85 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// blockingRunnable blocks until the ctx is done
+var blockingRunnable = RunnableFunc(func(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+})
+
+func TestGroup(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(PreShutdownDelay(0))
+	stopped := make(chan struct{})
+
+	g := NewGroup()
+	g.Signals = nil
+	g.AddService("service", s, 24888, 24999, noopReverseProxyFunc)
+	g.Add("worker", blockingRunnable)
+	g.Add("oneshot", RunnableFunc(func(ctx context.Context) error {
+		return nil
+	}))
+	g.Add("failing", RunnableFunc(func(ctx context.Context) error {
+		// wait for the service start
+		time.Sleep(500 * time.Millisecond)
+		return errors.New("boom")
+	}))
+	g.Add("stop hook", RunnableFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		close(stopped)
+		return errors.New("failed to stop")
+	}))
+
+	// the returned nil of oneshot does not stop the group, the failure stops all the others
+	err := g.Run(context.Background())
+	should.EqualError(err, "failing: boom; stop hook: failed to stop")
+	should.True(s.isShutdown())
+	should.Equal("service", s.name)
+	<-stopped
+}
+
+func TestGroupContext(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(PreShutdownDelay(0))
+
+	g := NewGroup()
+	g.Signals = nil
+	g.AddService("service", s, 24888, 24999, noopReverseProxyFunc)
+	g.Add("worker", blockingRunnable)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	should.NoError(g.Run(ctx))
+	should.True(s.isShutdown())
+}
+
+func TestGroupStopTimeout(t *testing.T) {
+	var should = require.New(t)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	g := NewGroup()
+	g.Signals = nil
+	g.StopTimeout = 100 * time.Millisecond
+	g.Add("stuck", RunnableFunc(func(ctx context.Context) error {
+		<-release
+		return nil
+	}))
+	g.Add("failing", RunnableFunc(func(ctx context.Context) error {
+		return errors.New("boom")
+	}))
+
+	err := g.Run(context.Background())
+	should.EqualError(err, "failing: boom; 1 members did not stop within 100ms")
+}
@@ -0,0 +1,180 @@
+package micro
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// the content type of gRPC, the subtype such as +proto follows
+const grpcContentType = "application/grpc"
+
+// grpcHTTPKey is the context key which marks the calls dispatched to the gRPC server over http
+type grpcHTTPKey struct{}
+
+// fromGRPCHTTP checks if the gRPC request is a gRPC-Web or Connect call served over http, the clients can not
+// set the context value
+func fromGRPCHTTP(ctx context.Context) bool {
+	_, ok := ctx.Value(grpcHTTPKey{}).(bool)
+	return ok
+}
+
+// grpcHTTPMiddleware dispatches the gRPC-Web and Connect calls to the gRPC server which serves them over http,
+// other requests are passed to the next
+func (s *Service) grpcHTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var serve func(w http.ResponseWriter, r *http.Request)
+		var reject func(w http.ResponseWriter)
+		switch {
+		case s.grpcWeb && isGRPCWebRequest(r):
+			serve, reject = s.serveGRPCWeb, rejectGRPCWeb
+		case s.connect && s.isConnectRequest(r):
+			serve, reject = s.serveConnect, rejectConnect
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, ok := s.grpcHTTP.begin(r.Context())
+		if !ok {
+			reject(w)
+			return
+		}
+		defer s.grpcHTTP.end()
+
+		serve(w, r.WithContext(context.WithValue(ctx, grpcHTTPKey{}, true)))
+	})
+}
+
+// grpcHTTPCalls tracks the calls which are served by the gRPC server over http and the streams bridged from
+// WebSocket or SSE, GracefulStop of the gRPC server can not drain them so that they are cancelled before it
+type grpcHTTPCalls struct {
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newGRPCHTTPCalls() *grpcHTTPCalls {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &grpcHTTPCalls{ctx: ctx, cancel: cancel}
+}
+
+// begin returns the ctx of the call which is cancelled once closed, it returns false if already closed
+func (c *grpcHTTPCalls) begin(parent context.Context) (context.Context, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, false
+	}
+	c.wg.Add(1)
+
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		defer cancel()
+		select {
+		case <-c.ctx.Done():
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, true
+}
+
+func (c *grpcHTTPCalls) end() {
+	c.wg.Done()
+}
+
+// isClosed checks if the calls are being cancelled on shutdown
+func (c *grpcHTTPCalls) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// close rejects the new calls and cancels the calls in flight, then waits for them to return until the ctx
+// is done
+func (c *grpcHTTPCalls) close(ctx context.Context) error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// disguiseHTTP2 clones the request as HTTP/2 with the gRPC content type which is required by the gRPC server
+func disguiseHTTP2(r *http.Request, contentType string) *http.Request {
+	req := r.Clone(r.Context())
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2"
+	req.ContentLength = -1
+	req.Header.Del("Content-Length")
+	req.Header.Set("Content-Type", contentType)
+
+	return req
+}
+
+// grpcResponseHeader separates the headers and the trailers written by the gRPC server over http, the trailers
+// are either declared by the Trailer header or prefixed by http2.TrailerPrefix
+type grpcResponseHeader struct {
+	header   http.Header
+	declared []string
+}
+
+func newGRPCResponseHeader() *grpcResponseHeader {
+	return &grpcResponseHeader{header: make(http.Header)}
+}
+
+// split returns the headers once they are written, the header map is replaced to receive the trailers
+func (h *grpcResponseHeader) split() http.Header {
+	headers := make(http.Header)
+	trailers := make(http.Header)
+	for k, v := range h.header {
+		switch {
+		case k == "Trailer":
+			h.declared = append(h.declared, v...)
+		case strings.HasPrefix(k, http2.TrailerPrefix):
+			trailers[k] = v
+		default:
+			headers[k] = v
+		}
+	}
+	h.header = trailers
+
+	return headers
+}
+
+// trailers returns the trailers with the lower case keys
+func (h *grpcResponseHeader) trailers() http.Header {
+	trailers := make(http.Header)
+	for _, k := range h.declared {
+		if v, ok := h.header[http.CanonicalHeaderKey(k)]; ok {
+			trailers[strings.ToLower(k)] = v
+		}
+	}
+	for k, v := range h.header {
+		if strings.HasPrefix(k, http2.TrailerPrefix) {
+			trailers[strings.ToLower(strings.TrimPrefix(k, http2.TrailerPrefix))] = v
+		}
+	}
+
+	return trailers
+}
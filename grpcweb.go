@@ -0,0 +1,172 @@
+package micro
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// the content types of gRPC-Web, the subtype such as +proto follows
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+	// the flag of the frame carrying the trailers in gRPC-Web responses
+	grpcWebTrailerFlag = 0x80
+)
+
+// isGRPCWebRequest checks if the request is a gRPC-Web call
+func isGRPCWebRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+// rejectGRPCWeb responds the trailers-only response of unavailable on shutdown
+func rejectGRPCWeb(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", grpcWebContentType)
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(codes.Unavailable)))
+	w.Header().Set("Grpc-Message", "service is shutting down")
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveGRPCWeb translates the gRPC-Web call in binary or text mode into the gRPC call served by the gRPC server
+// over http, the request is disguised as HTTP/2 and the trailers of the response are written as the last frame
+// of the body. The WriteTimeout of the ServerConfig limits the server-streaming calls, and the calls in flight
+// are cancelled on shutdown after the drain since GracefulStop can not wait for them
+func (s *Service) serveGRPCWeb(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(contentType, grpcWebTextContentType)
+
+	var req *http.Request
+	if text {
+		req = disguiseHTTP2(r, grpcContentType+strings.TrimPrefix(contentType, grpcWebTextContentType))
+		req.Body = ioutil.NopCloser(base64.NewDecoder(base64.StdEncoding, r.Body))
+	} else {
+		req = disguiseHTTP2(r, grpcContentType+strings.TrimPrefix(contentType, grpcWebContentType))
+	}
+
+	gw := newGRPCWebResponseWriter(w, text)
+	s.GRPCServer.ServeHTTP(gw, req)
+	gw.finish()
+}
+
+// grpcWebResponseWriter converts the gRPC response into gRPC-Web, the body is encoded by base64 in the text
+// mode and the trailers are written as a frame at the end
+type grpcWebResponseWriter struct {
+	w           http.ResponseWriter
+	header      *grpcResponseHeader
+	text        bool
+	wroteHeader bool
+	// the bytes not encoded by base64 yet in the text mode
+	pending []byte
+}
+
+func newGRPCWebResponseWriter(w http.ResponseWriter, text bool) *grpcWebResponseWriter {
+	return &grpcWebResponseWriter{
+		w:      w,
+		header: newGRPCResponseHeader(),
+		text:   text,
+	}
+}
+
+// Header implements http.ResponseWriter interface
+func (gw *grpcWebResponseWriter) Header() http.Header {
+	return gw.header.header
+}
+
+// WriteHeader implements http.ResponseWriter interface, the headers except the trailers are sent
+func (gw *grpcWebResponseWriter) WriteHeader(code int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+
+	header := gw.w.Header()
+	var exposed []string
+	for k, v := range gw.header.split() {
+		header[k] = v
+		exposed = append(exposed, k)
+	}
+
+	// the error responded by the gRPC server for the invalid request is not a gRPC response
+	contentType := header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, grpcContentType) {
+		gw.text = false
+		gw.w.WriteHeader(code)
+		return
+	}
+
+	webContentType := grpcWebContentType
+	if gw.text {
+		webContentType = grpcWebTextContentType
+	}
+	header.Set("Content-Type", webContentType+strings.TrimPrefix(contentType, grpcContentType))
+
+	// the browser exposes the metadata and the status to the client only if allowed by CORS
+	exposed = append(exposed, "Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin")
+	sort.Strings(exposed)
+	header.Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+
+	gw.w.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter interface
+func (gw *grpcWebResponseWriter) Write(b []byte) (int, error) {
+	gw.WriteHeader(http.StatusOK)
+	if !gw.text {
+		return gw.w.Write(b)
+	}
+
+	// encode the complete groups of 3 bytes so that the padding only appears on flush
+	gw.pending = append(gw.pending, b...)
+	n := len(gw.pending) / 3 * 3
+	if n > 0 {
+		if _, err := gw.w.Write([]byte(base64.StdEncoding.EncodeToString(gw.pending[:n]))); err != nil {
+			return 0, err
+		}
+		gw.pending = append(gw.pending[:0], gw.pending[n:]...)
+	}
+
+	return len(b), nil
+}
+
+// Flush implements http.Flusher interface which is required by the gRPC server
+func (gw *grpcWebResponseWriter) Flush() {
+	gw.WriteHeader(http.StatusOK)
+	if len(gw.pending) > 0 {
+		gw.w.Write([]byte(base64.StdEncoding.EncodeToString(gw.pending)))
+		gw.pending = gw.pending[:0]
+	}
+	if f, ok := gw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the trailers set by the gRPC server as the last frame
+func (gw *grpcWebResponseWriter) finish() {
+	trailers := gw.header.trailers()
+
+	// there is no trailer if the request is rejected by the gRPC server, e.g. the unsupported content type
+	if len(trailers) == 0 {
+		if gw.wroteHeader {
+			gw.Flush()
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	trailers.Write(&buf)
+
+	frame := make([]byte, 5, 5+buf.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(buf.Len()))
+	frame = append(frame, buf.Bytes()...)
+
+	gw.Write(frame)
+	gw.Flush()
+}
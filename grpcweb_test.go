@@ -0,0 +1,181 @@
+package micro
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// grpcWebFrame is a frame of gRPC-Web body
+type grpcWebFrame struct {
+	flag byte
+	data []byte
+}
+
+// encodeGRPCWebFrame encodes the message as a data frame
+func encodeGRPCWebFrame(t *testing.T, msg proto.Message) []byte {
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+
+	return append(frame, data...)
+}
+
+// readGRPCWebFrame reads the next frame
+func readGRPCWebFrame(t *testing.T, r io.Reader) grpcWebFrame {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(r, header)
+	require.NoError(t, err)
+
+	data := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	_, err = io.ReadFull(r, data)
+	require.NoError(t, err)
+
+	return grpcWebFrame{flag: header[0], data: data}
+}
+
+// decodeGRPCWebText decodes the body of the text mode in which the padding appears on each flush
+func decodeGRPCWebText(t *testing.T, body []byte) []byte {
+	var decoded []byte
+	for i := 0; i+4 <= len(body); i += 4 {
+		b, err := base64.StdEncoding.DecodeString(string(body[i : i+4]))
+		require.NoError(t, err)
+		decoded = append(decoded, b...)
+	}
+
+	return decoded
+}
+
+func TestGRPCWeb(t *testing.T) {
+	var should = require.New(t)
+
+	healthServer := health.NewServer()
+	s := NewService(GRPCWeb(), Health(healthServer), CORS("https://example.com"), PreShutdownDelay(0))
+	go s.Start(22888, 22999, noopReverseProxyFunc)
+	defer s.Stop()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	call := func(ctx context.Context, contentType string, body []byte) *http.Response {
+		req, err := http.NewRequest("POST", "http://127.0.0.1:22888/grpc.health.v1.Health/Check", bytes.NewReader(body))
+		should.NoError(err)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("X-Grpc-Web", "1")
+
+		resp, err := http.DefaultClient.Do(req)
+		should.NoError(err)
+
+		return resp
+	}
+
+	// binary mode
+	resp := call(context.Background(), "application/grpc-web+proto", encodeGRPCWebFrame(t, &healthpb.HealthCheckRequest{}))
+	should.Equal(http.StatusOK, resp.StatusCode)
+	should.Equal("application/grpc-web+proto", resp.Header.Get("Content-Type"))
+	should.Equal("https://example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	should.Contains(resp.Header.Get("Access-Control-Expose-Headers"), "Grpc-Status")
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	should.NoError(err)
+
+	reader := bytes.NewReader(body)
+	frame := readGRPCWebFrame(t, reader)
+	should.Equal(byte(0), frame.flag)
+	var checkResp healthpb.HealthCheckResponse
+	should.NoError(proto.Unmarshal(frame.data, &checkResp))
+	should.Equal(healthpb.HealthCheckResponse_SERVING, checkResp.Status)
+
+	frame = readGRPCWebFrame(t, reader)
+	should.Equal(byte(grpcWebTrailerFlag), frame.flag)
+	should.Equal("grpc-status: 0\r\n", string(frame.data))
+	should.Zero(reader.Len())
+
+	// text mode with the error in the trailers
+	requestBody := base64.StdEncoding.EncodeToString(encodeGRPCWebFrame(t, &healthpb.HealthCheckRequest{Service: "unknown"}))
+	resp = call(context.Background(), "application/grpc-web-text", []byte(requestBody))
+	should.Equal(http.StatusOK, resp.StatusCode)
+	should.Equal("application/grpc-web-text", resp.Header.Get("Content-Type"))
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	should.NoError(err)
+
+	reader = bytes.NewReader(decodeGRPCWebText(t, body))
+	frame = readGRPCWebFrame(t, reader)
+	should.Equal(byte(grpcWebTrailerFlag), frame.flag)
+	should.Equal("grpc-message: unknown service\r\ngrpc-status: 5\r\n", string(frame.data))
+
+	// the preflight request
+	req, err := http.NewRequest("OPTIONS", "http://127.0.0.1:22888/grpc.health.v1.Health/Check", nil)
+	should.NoError(err)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+	resp, err = http.DefaultClient.Do(req)
+	should.NoError(err)
+	resp.Body.Close()
+	should.Equal(http.StatusNoContent, resp.StatusCode)
+	should.Equal("content-type,x-grpc-web", resp.Header.Get("Access-Control-Allow-Headers"))
+
+	// the gateway routes are served alongside
+	resp, err = http.Get("http://127.0.0.1:22888/ready")
+	should.NoError(err)
+	resp.Body.Close()
+	should.Equal(http.StatusOK, resp.StatusCode)
+}
+
+func TestGRPCWebServerStreaming(t *testing.T) {
+	var should = require.New(t)
+
+	healthServer := health.NewServer()
+	s := NewService(GRPCWeb(), Health(healthServer), PreShutdownDelay(0))
+	go s.Start(21888, 21999, noopReverseProxyFunc)
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", "http://127.0.0.1:21888/grpc.health.v1.Health/Watch", bytes.NewReader(encodeGRPCWebFrame(t, &healthpb.HealthCheckRequest{Service: "test"})))
+	should.NoError(err)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/grpc-web")
+	resp, err := http.DefaultClient.Do(req)
+	should.NoError(err)
+	defer resp.Body.Close()
+	should.Equal("application/grpc-web", resp.Header.Get("Content-Type"))
+
+	// each message is flushed once sent
+	reader := bufio.NewReader(resp.Body)
+	var watchResp healthpb.HealthCheckResponse
+	frame := readGRPCWebFrame(t, reader)
+	should.NoError(proto.Unmarshal(frame.data, &watchResp))
+	should.Equal(healthpb.HealthCheckResponse_SERVICE_UNKNOWN, watchResp.Status)
+
+	healthServer.SetServingStatus("test", healthpb.HealthCheckResponse_SERVING)
+	frame = readGRPCWebFrame(t, reader)
+	should.NoError(proto.Unmarshal(frame.data, &watchResp))
+	should.Equal(healthpb.HealthCheckResponse_SERVING, watchResp.Status)
+
+	// the stream in flight is cancelled on shutdown and the new calls are rejected
+	should.NoError(s.Shutdown(context.Background()))
+	_, ok := s.grpcHTTP.begin(context.Background())
+	should.False(ok)
+}
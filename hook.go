@@ -0,0 +1,65 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// the default timeout of each lifecycle hook
+const defaultHookTimeout = 10 * time.Second
+
+// Hook is the lifecycle callback, the context is cancelled when the timeout of the hook is exceeded
+type Hook func(ctx context.Context) error
+
+type lifecycleHook struct {
+	hook    Hook
+	timeout time.Duration
+}
+
+// run calls the hook within its timeout, the hook is abandoned if it does not return in time
+func (h lifecycleHook) run() error {
+	timeout := h.timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- h.hook(ctx)
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runStartHooks calls the start hooks in order and stops at the first error, it returns the number of the
+// completed hooks
+func (s *Service) runStartHooks() (int, error) {
+	for i, h := range s.startHooks {
+		if err := h.run(); err != nil {
+			return i, fmt.Errorf("start hook #%d failed: %v", i+1, err)
+		}
+	}
+
+	return len(s.startHooks), nil
+}
+
+// runStopHooks calls all the stop hooks in reverse order, the errors are aggregated
+func (s *Service) runStopHooks() error {
+	var errs MultiError
+	for i := len(s.stopHooks) - 1; i >= 0; i-- {
+		if err := s.stopHooks[i].run(); err != nil {
+			errs = append(errs, fmt.Errorf("stop hook #%d failed: %v", i+1, err))
+		}
+	}
+
+	return errs.errorOrNil()
+}
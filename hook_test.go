@@ -0,0 +1,86 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartHooks(t *testing.T) {
+	var should = require.New(t)
+
+	var calls []string
+	s := NewService(
+		OnStart(func(ctx context.Context) error {
+			calls = append(calls, "first")
+			return nil
+		}, 0),
+		OnStart(func(ctx context.Context) error {
+			calls = append(calls, "second")
+			return errors.New("not ready")
+		}, 0),
+		OnStart(func(ctx context.Context) error {
+			calls = append(calls, "third")
+			return nil
+		}, 0),
+		OnStop(func(ctx context.Context) error {
+			calls = append(calls, "stop")
+			return nil
+		}, 0),
+	)
+
+	// the startup is aborted before listening and the stop hooks release what the first hook started
+	err := s.Start(28888, 29999, reverseProxyFunc)
+	should.EqualError(err, "start hook #2 failed: not ready")
+	should.Equal([]string{"first", "second", "stop"}, calls)
+
+	// the stop hooks are not called if no start hook completed
+	calls = nil
+	s = NewService(
+		OnStart(func(ctx context.Context) error {
+			calls = append(calls, "first")
+			return errors.New("not ready")
+		}, 0),
+		OnStop(func(ctx context.Context) error {
+			calls = append(calls, "stop")
+			return nil
+		}, 0),
+	)
+	err = s.Start(28888, 29999, reverseProxyFunc)
+	should.EqualError(err, "start hook #1 failed: not ready")
+	should.Equal([]string{"first"}, calls)
+}
+
+func TestStopHooks(t *testing.T) {
+	var should = require.New(t)
+
+	var calls []string
+	s := NewService(
+		OnStop(func(ctx context.Context) error {
+			calls = append(calls, "first")
+			return errors.New("failed to flush")
+		}, 0),
+		OnStop(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		}, 10*time.Millisecond),
+		OnStop(func(ctx context.Context) error {
+			calls = append(calls, "third")
+			return nil
+		}, 0),
+	)
+
+	// the hooks are called in reverse order and the errors are aggregated
+	err := s.runStopHooks()
+	should.Equal([]string{"third", "first"}, calls)
+	should.EqualError(err, "stop hook #2 failed: context deadline exceeded; stop hook #1 failed: failed to flush")
+	should.Len(err, 2)
+
+	should.NoError(NewService().runStopHooks())
+
+	_, err = New(OnStop(nil, 0))
+	should.EqualError(err, "stop hook must not be nil")
+}
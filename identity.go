@@ -0,0 +1,169 @@
+package micro
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// identityMetadataKey is the metadata key carrying the client identity forwarded by the gateway
+const identityMetadataKey = "x-micro-client-identity"
+
+// Identity represents the client identity extracted from a verified client certificate
+type Identity struct {
+	// CommonName is the subject common name
+	CommonName string `json:"cn,omitempty"`
+	// DNSNames are the DNS subject alternative names
+	DNSNames []string `json:"dns,omitempty"`
+	// EmailAddresses are the email subject alternative names
+	EmailAddresses []string `json:"email,omitempty"`
+	// URIs are the URI subject alternative names
+	URIs []string `json:"uri,omitempty"`
+	// SPIFFEID is the first URI SAN with the spiffe scheme
+	SPIFFEID string `json:"spiffe,omitempty"`
+}
+
+// IdentityAuthorizer is the authorization hook keyed on the client identity, the identity is nil
+// if the client did not present a verified certificate
+type IdentityAuthorizer func(ctx context.Context, fullMethod string, id *Identity) error
+
+type identityKey struct{}
+
+// IdentityFromCertificate extracts the identity from the certificate
+func IdentityFromCertificate(cert *x509.Certificate) *Identity {
+	id := &Identity{
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+	}
+
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+		if id.SPIFFEID == "" && uri.Scheme == "spiffe" {
+			id.SPIFFEID = uri.String()
+		}
+	}
+
+	return id
+}
+
+// NewIdentityContext returns a new context carrying the identity
+func NewIdentityContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the client identity stored in the context
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok && id != nil
+}
+
+// identityFromChains returns the identity of the leaf certificate of the first verified chain
+func identityFromChains(chains [][]*x509.Certificate) *Identity {
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil
+	}
+
+	return IdentityFromCertificate(chains[0][0])
+}
+
+// identityAnnotator forwards the identity of the http client to gRPC metadata, the value is
+// signed so that it can not be forged by the clients
+func (s *Service) identityAnnotator(ctx context.Context, r *http.Request) metadata.MD {
+	if r.TLS == nil {
+		return nil
+	}
+
+	id := identityFromChains(r.TLS.VerifiedChains)
+	if id == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(id)
+	if err != nil {
+		return nil
+	}
+
+	return metadata.Pairs(identityMetadataKey, s.signGateway(payload))
+}
+
+// identityFromGRPC extracts the identity forwarded by the gateway or the one from the peer certificate, the
+// peer certificate of the requests forwarded by the gateway is the one of the gateway so that it is ignored
+func (s *Service) identityFromGRPC(ctx context.Context) *Identity {
+	if payload, ok := s.gatewayPayload(ctx, identityMetadataKey); ok {
+		var id Identity
+		if err := json.Unmarshal(payload, &id); err == nil {
+			return &id
+		}
+	}
+	if s.fromGateway(ctx) {
+		return nil
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+
+	return identityFromChains(tlsInfo.State.VerifiedChains)
+}
+
+// authorizeIdentity puts the identity into the context and calls the authorizer
+func (s *Service) authorizeIdentity(ctx context.Context, fullMethod string, authorizer IdentityAuthorizer) (context.Context, error) {
+	id := s.identityFromGRPC(ctx)
+	if id != nil {
+		ctx = NewIdentityContext(ctx, id)
+	}
+
+	if authorizer != nil {
+		if err := authorizer(ctx, fullMethod, id); err != nil {
+			if _, ok := status.FromError(err); ok {
+				return nil, err
+			}
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+
+	return ctx, nil
+}
+
+// identityUnaryInterceptor returns the unary interceptor which extracts the client identity
+func (s *Service) identityUnaryInterceptor(authorizer IdentityAuthorizer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := s.authorizeIdentity(ctx, info.FullMethod, authorizer)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// identityStreamInterceptor returns the stream interceptor which extracts the client identity
+func (s *Service) identityStreamInterceptor(authorizer IdentityAuthorizer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := s.authorizeIdentity(stream.Context(), info.FullMethod, authorizer)
+		if err != nil {
+			return err
+		}
+
+		wrapped := grpc_middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = ctx
+
+		return handler(srv, wrapped)
+	}
+}
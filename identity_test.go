@@ -0,0 +1,106 @@
+package micro
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func testClientCertificate() *x509.Certificate {
+	spiffe, _ := url.Parse("spiffe://example.org/client")
+	return &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "client"},
+		DNSNames: []string{"client.example.org"},
+		URIs:     []*url.URL{spiffe},
+	}
+}
+
+func TestIdentityFromCertificate(t *testing.T) {
+	var should = require.New(t)
+
+	id := IdentityFromCertificate(testClientCertificate())
+	should.Equal("client", id.CommonName)
+	should.Equal([]string{"client.example.org"}, id.DNSNames)
+	should.Equal("spiffe://example.org/client", id.SPIFFEID)
+}
+
+func TestIdentityUnaryInterceptor(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	var got *Identity
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		got, _ = IdentityFromContext(ctx)
+		return nil, nil
+	}
+
+	// identity from peer certificate
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{testClientCertificate()}},
+		}},
+	})
+	_, err := s.identityUnaryInterceptor(nil)(ctx, nil, info, handler)
+	should.NoError(err)
+	should.Equal("client", got.CommonName)
+
+	// the peer certificate of the gateway is not the identity of the anonymous http clients
+	ctx = metadata.NewIncomingContext(ctx, s.clientIPAnnotator(context.Background(), httptest.NewRequest("GET", "/", nil)))
+	_, err = s.identityUnaryInterceptor(nil)(ctx, nil, info, handler)
+	should.NoError(err)
+	should.Nil(got)
+
+	// identity forwarded by the gateway
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{testClientCertificate()}},
+	}
+	md := s.identityAnnotator(context.Background(), req)
+	ctx = metadata.NewIncomingContext(context.Background(), md)
+	_, err = s.identityUnaryInterceptor(nil)(ctx, nil, info, handler)
+	should.NoError(err)
+	should.Equal("spiffe://example.org/client", got.SPIFFEID)
+
+	// forged identity is ignored
+	got = nil
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(identityMetadataKey, "e30.forged"))
+	_, err = s.identityUnaryInterceptor(nil)(ctx, nil, info, handler)
+	should.NoError(err)
+	should.Nil(got)
+
+	// identity signed by another service is ignored
+	ctx = metadata.NewIncomingContext(context.Background(), md)
+	_, err = NewService().identityUnaryInterceptor(nil)(ctx, nil, info, handler)
+	should.NoError(err)
+	should.Nil(got)
+
+	// the authorizer rejects the request
+	authorizer := func(ctx context.Context, fullMethod string, id *Identity) error {
+		if id == nil {
+			return errors.New("client certificate required")
+		}
+		return nil
+	}
+	_, err = s.identityUnaryInterceptor(authorizer)(context.Background(), nil, info, handler)
+	should.Equal(codes.PermissionDenied, status.Code(err))
+}
+
+func TestIdentityAnnotatorWithoutTLS(t *testing.T) {
+	s := NewService()
+	req := httptest.NewRequest("GET", "/", nil)
+	require.Nil(t, s.identityAnnotator(context.Background(), req))
+}
@@ -0,0 +1,365 @@
+package micro
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	// register the hash functions used by the supported algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// JWTOpts is configures for the JWT authenticator
+type JWTOpts struct {
+	// JWKSFile is the path of the local JWKS file containing the verification keys
+	JWKSFile string
+	// Keys are the static verification keys, format: kid -> key, the key should be []byte for HS
+	// algorithms, *rsa.PublicKey for RS algorithms and *ecdsa.PublicKey for ES algorithms,
+	// the key with empty kid is used for the tokens without kid
+	Keys map[string]interface{}
+	// Issuer is the expected iss claim, it is not checked if empty
+	Issuer string
+	// Audience is the expected aud claim, it is not checked if empty
+	Audience string
+	// Leeway is the allowed clock skew when checking exp and nbf
+	Leeway time.Duration
+	// AllowMissingExp accepts the tokens without the exp claim which never expire, they are rejected by default
+	AllowMissingExp bool
+	// SkipMethods are the full method names which do not require the token, path.Match patterns
+	// like /package.Service/* are supported
+	SkipMethods []string
+}
+
+// JWTAuthenticator authenticates the requests with the bearer JWT in the authorization metadata
+type JWTAuthenticator struct {
+	opts JWTOpts
+	keys map[string]interface{}
+	// the algorithms of the JWKS keys which set alg, format: kid -> alg
+	algs map[string]string
+	now  func() time.Time
+}
+
+// jwtHeader is the JOSE header of the token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwk is the JSON web key, only the public parameters are supported
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+var jwtAlgorithms = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// NewJWTAuthenticator creates a new JWT authenticator, the JWKS file is loaded immediately
+func NewJWTAuthenticator(opts JWTOpts) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{
+		opts: opts,
+		keys: make(map[string]interface{}),
+		algs: make(map[string]string),
+		now:  time.Now,
+	}
+
+	if opts.JWKSFile != "" {
+		data, err := ioutil.ReadFile(opts.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+
+		keys, algs, err := parseJWKS(data)
+		if err != nil {
+			return nil, fmt.Errorf("jwks %s: %v", opts.JWKSFile, err)
+		}
+
+		a.keys, a.algs = keys, algs
+	}
+
+	for kid, key := range opts.Keys {
+		switch key.(type) {
+		case []byte, *rsa.PublicKey, *ecdsa.PublicKey:
+			a.keys[kid] = key
+			delete(a.algs, kid)
+		default:
+			return nil, fmt.Errorf("unsupported key type %T of kid %q", key, kid)
+		}
+	}
+
+	if len(a.keys) == 0 {
+		return nil, errors.New("no verification keys for JWT")
+	}
+
+	return a, nil
+}
+
+// ParseJWKS parses the JSON web key set, format: kid -> key
+func ParseJWKS(data []byte) (map[string]interface{}, error) {
+	keys, _, err := parseJWKS(data)
+	return keys, err
+}
+
+// parseJWKS parses the JSON web key set and the algorithms of the keys which set alg, format: kid -> alg
+func parseJWKS(data []byte) (map[string]interface{}, map[string]string, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, nil, err
+	}
+
+	keys := make(map[string]interface{})
+	algs := make(map[string]string)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, nil, fmt.Errorf("key %q: %v", k.Kid, err)
+		}
+
+		keys[k.Kid] = key
+		if k.Alg != "" {
+			algs[k.Kid] = k.Alg
+		}
+	}
+
+	return keys, algs, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// Authenticate implements Authenticator interface
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if matchMethods(a.opts.SkipMethods, fullMethod) {
+		return ctx, nil
+	}
+
+	token, ok := bearerToken(ctx)
+	if !ok {
+		return ctx, ErrMissingCredentials
+	}
+
+	claims, err := a.Verify(token)
+	if err != nil {
+		return ctx, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+
+	return NewClaimsContext(ctx, claims), nil
+}
+
+// Verify verifies the signature and the registered claims of the token and returns its claims
+func (a *JWTAuthenticator) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %v", err)
+	}
+
+	hash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	key, ok := a.keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", header.Kid)
+	}
+
+	// the key is bound to its algorithm if the JWKS sets it
+	if alg, ok := a.algs[header.Kid]; ok && alg != header.Alg {
+		return nil, fmt.Errorf("algorithm %q does not match the key", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+
+	if err := verifyJWTSignature(header.Alg, hash, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %v", err)
+	}
+
+	if err := a.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) validate(claims Claims) error {
+	now := a.now()
+
+	exp, ok := claims.time("exp")
+	if !ok && !a.opts.AllowMissingExp {
+		return errors.New("token has no expiration")
+	}
+	if ok && now.After(exp.Add(a.opts.Leeway)) {
+		return errors.New("token is expired")
+	}
+
+	if nbf, ok := claims.time("nbf"); ok && now.Add(a.opts.Leeway).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+
+	if a.opts.Issuer != "" && claims.Issuer() != a.opts.Issuer {
+		return errors.New("invalid issuer")
+	}
+
+	if a.opts.Audience != "" {
+		for _, aud := range claims.Audience() {
+			if aud == a.opts.Audience {
+				return nil
+			}
+		}
+		return errors.New("invalid audience")
+	}
+
+	return nil
+}
+
+func verifyJWTSignature(alg string, hash crypto.Hash, key interface{}, signed, sig []byte) error {
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("key is not suitable for %s", alg)
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return errors.New("invalid signature")
+		}
+
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key is not suitable for %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return errors.New("invalid signature")
+		}
+
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key is not suitable for %s", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+	}
+
+	return nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
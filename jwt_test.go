@@ -0,0 +1,180 @@
+package micro
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func signTestJWT(t *testing.T, alg, kid string, key interface{}, claims Claims) string {
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := jwtAlgorithms[alg]
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest)
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		require.NoError(t, err)
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	var should = require.New(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	should.NoError(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	should.NoError(err)
+
+	// write the JWKS file with RSA and EC keys
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "rsa",
+				"n":   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "EC",
+				"kid": "ec",
+				"alg": "ES256",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+				"y":   base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+			},
+		},
+	})
+	dir, err := ioutil.TempDir("", "jwks")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+	jwksFile := filepath.Join(dir, "jwks.json")
+	should.NoError(ioutil.WriteFile(jwksFile, jwks, 0644))
+
+	secret := []byte("secret")
+	a, err := NewJWTAuthenticator(JWTOpts{
+		JWKSFile:    jwksFile,
+		Keys:        map[string]interface{}{"": secret},
+		Issuer:      "issuer",
+		Audience:    "micro",
+		SkipMethods: []string{"/grpc.health.v1.Health/*"},
+	})
+	should.NoError(err)
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	claims := Claims{"sub": "alice", "iss": "issuer", "aud": []string{"micro"}, "exp": exp}
+
+	for _, token := range []string{
+		signTestJWT(t, "HS256", "", secret, claims),
+		signTestJWT(t, "RS256", "rsa", rsaKey, claims),
+		signTestJWT(t, "ES256", "ec", ecKey, claims),
+	} {
+		verified, err := a.Verify(token)
+		should.NoError(err)
+		should.Equal("alice", verified.Subject())
+	}
+
+	// wrong key type for the algorithm
+	_, err = a.Verify(signTestJWT(t, "HS256", "rsa", secret, claims))
+	should.Error(err)
+
+	// invalid registered claims
+	_, err = a.Verify(signTestJWT(t, "HS256", "", secret, Claims{"iss": "issuer", "aud": "micro", "exp": float64(1)}))
+	should.EqualError(err, "token is expired")
+	_, err = a.Verify(signTestJWT(t, "HS256", "", secret, Claims{"iss": "other", "aud": "micro", "exp": exp}))
+	should.EqualError(err, "invalid issuer")
+	_, err = a.Verify(signTestJWT(t, "HS256", "", secret, Claims{"iss": "issuer", "aud": "other", "exp": exp}))
+	should.EqualError(err, "invalid audience")
+
+	// the token without exp is rejected unless allowed
+	noExp := Claims{"iss": "issuer", "aud": "micro"}
+	_, err = a.Verify(signTestJWT(t, "HS256", "", secret, noExp))
+	should.EqualError(err, "token has no expiration")
+	allowing, err := NewJWTAuthenticator(JWTOpts{Keys: map[string]interface{}{"": secret}, AllowMissingExp: true})
+	should.NoError(err)
+	_, err = allowing.Verify(signTestJWT(t, "HS256", "", secret, noExp))
+	should.NoError(err)
+
+	// the algorithm of the token must match the one of the JWKS key
+	_, err = a.Verify(signTestJWT(t, "ES384", "ec", ecKey, claims))
+	should.EqualError(err, `algorithm "ES384" does not match the key`)
+
+	// authenticate through the interceptor
+	s := NewService(Authentication(a))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		claims, _ := ClaimsFromContext(ctx)
+		return claims.Subject(), nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer "+signTestJWT(t, "ES256", "ec", ecKey, claims),
+	))
+	resp, err := s.authUnaryInterceptor(ctx, nil, info, handler)
+	should.NoError(err)
+	should.Equal("alice", resp)
+
+	_, err = s.authUnaryInterceptor(context.Background(), nil, info, handler)
+	should.Equal(codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer invalid"))
+	_, err = s.authUnaryInterceptor(ctx, nil, info, handler)
+	should.Equal(codes.Unauthenticated, status.Code(err))
+
+	// skipped method
+	info.FullMethod = "/grpc.health.v1.Health/Check"
+	resp, err = s.authUnaryInterceptor(context.Background(), nil, info, handler)
+	should.NoError(err)
+	should.Equal("", resp)
+}
+
+func TestNewJWTAuthenticatorErrors(t *testing.T) {
+	var should = require.New(t)
+
+	_, err := NewJWTAuthenticator(JWTOpts{})
+	should.Error(err)
+
+	_, err = NewJWTAuthenticator(JWTOpts{JWKSFile: "/not/exist.json"})
+	should.Error(err)
+
+	_, err = NewJWTAuthenticator(JWTOpts{Keys: map[string]interface{}{"": "secret"}})
+	should.Error(err)
+}
@@ -0,0 +1,148 @@
+package micro
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the names of the listeners
+const (
+	grpcListenerName = "grpc"
+	httpListenerName = "http"
+)
+
+// the inherited listeners of the process keyed by listenerKey, each of them can be taken once
+var (
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	inherited   map[string]net.Listener
+	// the number of the inherited listeners taken by the services which are not ready yet
+	inheritPending int
+	inheritErr     error
+)
+
+// listenerKey tells apart the listeners of the services in the same process by the ports, the new process
+// in graceful restart starts the services on the same ports
+func listenerKey(name string, port uint) string {
+	return fmt.Sprintf("%s-%d", name, port)
+}
+
+// listen returns the listener of the server, it is inherited from the parent process in graceful restart
+// or systemd socket activation if passed, or created on the port otherwise
+func (s *Service) listen(name string, port uint) (net.Listener, error) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	key := listenerKey(name, port)
+	if lis, ok := s.listeners[key]; ok {
+		return lis, nil
+	}
+
+	lis, err := inheritedListener(key)
+	if err != nil {
+		return nil, err
+	}
+	if lis != nil {
+		s.inheritedListeners++
+	}
+
+	if lis == nil && s.systemd {
+		if lis, err = systemdListener(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if lis != nil {
+		s.logger.Printf("Inherited %s listener on %v", name, lis.Addr())
+	} else {
+		lis, err = net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.listeners == nil {
+		s.listeners = make(map[string]net.Listener)
+	}
+	s.listeners[key] = lis
+
+	return lis, nil
+}
+
+// closeListeners closes the listeners which are not served yet
+func (s *Service) closeListeners() {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	for key, lis := range s.listeners {
+		lis.Close()
+		delete(s.listeners, key)
+	}
+}
+
+// inheritedListener takes the listener passed by the parent process, it returns nil if not passed
+func inheritedListener(key string) (net.Listener, error) {
+	inheritOnce.Do(func() {
+		inherited, inheritErr = listenersFromEnv()
+	})
+
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+
+	lis, ok := inherited[key]
+	if ok {
+		delete(inherited, key)
+		inheritPending++
+	}
+
+	return lis, inheritErr
+}
+
+// listenersFromEnv creates the listeners from the file descriptors passed by the parent process in
+// graceful restart, the environment variable is unset so that it is not passed down again
+func listenersFromEnv() (map[string]net.Listener, error) {
+	value := os.Getenv(listenFDsEnv)
+	if value == "" {
+		return nil, nil
+	}
+	os.Unsetenv(listenFDsEnv)
+
+	listeners := make(map[string]net.Listener)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s: %s", listenFDsEnv, value)
+		}
+
+		fd, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", listenFDsEnv, value)
+		}
+
+		lis, err := fileListener(fd, parts[0])
+		if err != nil {
+			return nil, err
+		}
+		listeners[parts[0]] = lis
+	}
+
+	return listeners, nil
+}
+
+// fileListener creates the listener from the file descriptor, the descriptor is closed as the listener
+// holds a duplicate of it
+func fileListener(fd int, name string) (net.Listener, error) {
+	file := os.NewFile(uintptr(fd), name)
+	defer file.Close()
+
+	lis, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inherit %s listener from fd %d: %v", name, fd, err)
+	}
+
+	return lis, nil
+}
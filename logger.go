@@ -1,5 +1,10 @@
 package micro
 
+import (
+	"fmt"
+	"sync/atomic"
+)
+
 // Logger is logger interface
 type Logger interface {
 	Printf(string, ...interface{})
@@ -13,3 +18,65 @@ func (f LoggerFunc) Printf(msg string, args ...interface{}) { f(msg, args...) }
 
 // dummy logger writes nothing
 var dummyLogger = LoggerFunc(func(string, ...interface{}) {})
+
+// the log levels, the messages of the service are logged at the info level unless noted
+const (
+	logLevelDebug int32 = iota
+	logLevelInfo
+	logLevelWarn
+)
+
+// logLevels maps the names of the log levels, empty means info
+var logLevels = map[string]int32{
+	"":      logLevelInfo,
+	"debug": logLevelDebug,
+	"info":  logLevelInfo,
+	"warn":  logLevelWarn,
+}
+
+// leveledLogger drops the messages below the level, the level can be changed at runtime
+type leveledLogger struct {
+	Logger
+	level int32
+	// the level before debug is toggled on
+	toggled int32
+}
+
+// Printf implements Logger interface, the message is logged at the info level
+func (l *leveledLogger) Printf(msg string, args ...interface{}) {
+	l.logf(logLevelInfo, msg, args...)
+}
+
+func (l *leveledLogger) logf(level int32, msg string, args ...interface{}) {
+	if level >= atomic.LoadInt32(&l.level) {
+		l.Logger.Printf(msg, args...)
+	}
+}
+
+func (l *leveledLogger) setLevel(name string) error {
+	level, ok := logLevels[name]
+	if !ok {
+		return fmt.Errorf("invalid log level: %s", name)
+	}
+	atomic.StoreInt32(&l.level, level)
+
+	return nil
+}
+
+// toggleDebug switches between the debug level and the level before, it returns true if debug is on
+func (l *leveledLogger) toggleDebug() bool {
+	if level := atomic.LoadInt32(&l.level); level != logLevelDebug {
+		atomic.StoreInt32(&l.toggled, level)
+		atomic.StoreInt32(&l.level, logLevelDebug)
+		return true
+	}
+
+	// the level is debug from the start
+	level := atomic.LoadInt32(&l.toggled)
+	if level == logLevelDebug {
+		level = logLevelInfo
+	}
+	atomic.StoreInt32(&l.level, level)
+
+	return false
+}
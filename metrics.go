@@ -0,0 +1,61 @@
+package micro
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	concurrencyLimitGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "micro_concurrency_limit",
+		Help: "Current concurrency limit of the server.",
+	}, []string{"service", "scope"})
+
+	concurrencyInflightGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "micro_concurrency_inflight",
+		Help: "Number of in-flight requests counted by the concurrency limiter.",
+	}, []string{"service", "scope"})
+
+	concurrencyRejectedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "micro_concurrency_rejected_total",
+		Help: "Total number of requests rejected by the concurrency limiter.",
+	}, []string{"service", "scope"})
+
+	inflightRequestsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "micro_inflight_requests",
+		Help: "Number of in-flight requests of the gRPC and http servers.",
+	}, []string{"service", "server"})
+
+	jobRunsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "micro_job_runs_total",
+		Help: "Total number of runs of the scheduled jobs.",
+	}, []string{"job"})
+
+	jobFailuresCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "micro_job_failures_total",
+		Help: "Total number of failed runs of the scheduled jobs, including the panics.",
+	}, []string{"job"})
+
+	jobSkippedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "micro_job_skipped_total",
+		Help: "Total number of runs skipped since the previous run of the same job was in progress.",
+	}, []string{"job"})
+
+	jobDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "micro_job_duration_seconds",
+		Help:    "Duration of the runs of the scheduled jobs.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"job"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		concurrencyLimitGauge,
+		concurrencyInflightGauge,
+		concurrencyRejectedCounter,
+		inflightRequestsGauge,
+		jobRunsCounter,
+		jobFailuresCounter,
+		jobSkippedCounter,
+		jobDurationHistogram,
+	)
+}
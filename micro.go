@@ -2,13 +2,18 @@ package micro
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
@@ -18,40 +23,96 @@ import (
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 // Service represents the microservice
 type Service struct {
-	GRPCServer         *grpc.Server
-	HTTPServer         *http.Server
-	httpHandler        HTTPHandlerFunc
-	errorHandler       runtime.ErrorHandlerFunc
-	annotators         []AnnotatorFunc
-	redoc              *RedocOpts
-	staticDir          string
-	muxOptions         []runtime.ServeMuxOption
-	mux                *runtime.ServeMux
-	routes             []Route
-	streamInterceptors []grpc.StreamServerInterceptor
-	unaryInterceptors  []grpc.UnaryServerInterceptor
-	shutdownFunc       func()
-	shutdownTimeout    time.Duration
-	preShutdownDelay   time.Duration
-	interruptSignals   []os.Signal
-	grpcServerOptions  []grpc.ServerOption
-	grpcDialOptions    []grpc.DialOption
-	logger             Logger
+	GRPCServer            *grpc.Server
+	HTTPServer            *http.Server
+	httpHandler           HTTPHandlerFunc
+	errorHandler          runtime.ErrorHandlerFunc
+	annotators            []AnnotatorFunc
+	redoc                 *RedocOpts
+	staticDir             string
+	muxOptions            []runtime.ServeMuxOption
+	outgoingHeaderMatcher runtime.HeaderMatcherFunc
+	mux                   *runtime.ServeMux
+	routes                []Route
+	routesMu              sync.RWMutex
+	streamInterceptors    []grpc.StreamServerInterceptor
+	unaryInterceptors     []grpc.UnaryServerInterceptor
+	shutdownFunc          func()
+	shutdownTimeout       time.Duration
+	preShutdownDelay      time.Duration
+	signalActions         map[os.Signal]SignalAction
+	grpcServerOptions     []grpc.ServerOption
+	grpcDialOptions       []grpc.DialOption
+	logger                Logger
+	gatewayKey            []byte
+	tlsConfig             *tls.Config
+	authenticators        []Authenticator
+	policies              *Policies
+	rateLimiter           *rateLimiter
+	concurrencyLimiter    *concurrencyLimiter
+	clientIPForwarded     bool
+	defaultTimeout        time.Duration
+	methodTimeouts        map[string]time.Duration
+	timeoutMethods        *methodMatcher
+	serverConfig          ServerConfig
+	name                  string
+	logLevel              string
+	log                   *leveledLogger
+	cors                  *cors
+	config                *Config
+	certificate           *atomic.Value
+	reloadMu              sync.Mutex
+	redocMu               sync.RWMutex
+	startHooks            []lifecycleHook
+	stopHooks             []lifecycleHook
+	shutdownMu            sync.Mutex
+	shutdownStarted       bool
+	shutdownDone          chan struct{}
+	shutdownErr           error
+	healthServer          *health.Server
+	ready                 int32
+	grpcInflight          int32
+	httpInflight          int32
+	listenersMu           sync.Mutex
+	listeners             map[string]net.Listener
+	inheritedListeners    int
+	restartTimeout        time.Duration
+	restartCommand        func() (*exec.Cmd, error)
+	systemd               bool
+	scheduler             *Scheduler
+	grpcWeb               bool
+	connect               bool
+	connectMethods        map[string]protoreflect.MethodDescriptor
+	grpcHTTP              *grpcHTTPCalls
+	grpcStopOnce          sync.Once
+	grpcStopped           chan struct{}
+	webSocket             *WebSocketOpts
+	sse                   *SSEOpts
+	schedulerMu           sync.Mutex
+	schedulerCancel       context.CancelFunc
+	schedulerDone         chan struct{}
+	schedulerStopped      bool
 }
 
 const (
 	// the default timeout before the server shutdown abruptly
 	defaultShutdownTimeout = 30 * time.Second
-	// the default time waiting for running goroutines to finish their jobs before the shutdown starts
+	// the default maximum time waiting for the in-flight requests to finish before the shutdown starts
 	defaultPreShutdownDelay = 1 * time.Second
 )
 
+// ErrServiceStopped is returned by Start if the service has been shut down
+var ErrServiceStopped = errors.New("micro: service has been shut down")
+
 // ReverseProxyFunc is the callback that the caller should implement to steps to reverse-proxy the HTTP/1 requests to gRPC
 type ReverseProxyFunc func(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error
 
@@ -76,13 +137,18 @@ func defaultService() *Service {
 	s.shutdownTimeout = defaultShutdownTimeout
 	s.preShutdownDelay = defaultPreShutdownDelay
 	s.logger = dummyLogger
+	s.gatewayKey = newGatewayKey()
+	s.serverConfig = DefaultServerConfig()
+	s.shutdownDone = make(chan struct{})
+	s.restartTimeout = defaultRestartTimeout
+	s.restartCommand = defaultRestartCommand
 
 	s.redoc = &RedocOpts{
 		Up: false,
 	}
 
-	// default interrupt signals to catch, you can use InterruptSignal option to append more
-	s.interruptSignals = InterruptSignals
+	// default signals to catch, you can use Signal option to change them
+	s.signalActions = defaultSignalActions()
 
 	s.streamInterceptors = []grpc.StreamServerInterceptor{}
 	s.unaryInterceptors = []grpc.UnaryServerInterceptor{}
@@ -112,19 +178,78 @@ func defaultService() *Service {
 	return &s
 }
 
-// NewService creates a new microservice
-func NewService(opts ...Option) *Service {
+// New creates a new microservice, it returns the errors of all the failed options or the error if the final
+// configuration is invalid
+func New(opts ...Option) (*Service, error) {
 	s := defaultService()
 
-	s.apply(opts...)
+	errs := s.apply(opts...)
+	s.initLogger()
+	if err := errs.errorOrNil(); err != nil {
+		return nil, err
+	}
+
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	s.init()
+
+	return s, nil
+}
+
+// NewService creates a new microservice like New but panics if any option fails or the final configuration
+// is invalid so that the misconfiguration is not ignored
+func NewService(opts ...Option) *Service {
+	s, err := New(opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
 
+// initLogger wraps the logger with the log level
+func (s *Service) initLogger() {
+	s.log = &leveledLogger{Logger: s.logger}
+	s.log.setLevel(s.logLevel)
+	s.logger = s.log
+}
+
+// validate validates the final configuration of the service
+func (s *Service) validate() error {
+	if err := s.serverConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid server config: %v", err)
+	}
+
+	if err := s.redoc.Validate(); err != nil {
+		return fmt.Errorf("invalid redoc options: %v", err)
+	}
+
+	return nil
+}
+
+// init initializes the gateway mux and the servers with the options
+func (s *Service) init() {
 	// default dial option is using insecure connection
 	if len(s.grpcDialOptions) == 0 {
 		s.grpcDialOptions = append(s.grpcDialOptions, grpc.WithInsecure())
 	}
 
-	// init gateway mux
-	s.muxOptions = append(s.muxOptions, runtime.WithErrorHandler(s.errorHandler))
+	// init gateway mux, the header matcher goes first so that the one set by MuxOption takes precedence
+	headerMatcher := s.outgoingHeaderMatcher
+	if s.rateLimiter != nil {
+		headerMatcher = retryAfterHeaderMatcher(headerMatcher)
+	}
+	if headerMatcher != nil {
+		s.muxOptions = append([]runtime.ServeMuxOption{runtime.WithOutgoingHeaderMatcher(headerMatcher)}, s.muxOptions...)
+	}
+
+	errorHandler := s.errorHandler
+	if s.sse != nil {
+		errorHandler = sseErrorHandler(errorHandler)
+	}
+	s.muxOptions = append(s.muxOptions, runtime.WithErrorHandler(errorHandler))
 
 	for _, annotator := range s.annotators {
 		s.muxOptions = append(s.muxOptions, runtime.WithMetadata(annotator))
@@ -132,18 +257,67 @@ func NewService(opts ...Option) *Service {
 
 	s.mux = runtime.NewServeMux(s.muxOptions...)
 
-	s.grpcServerOptions = append(s.grpcServerOptions, grpc_middleware.WithStreamServerChain(s.streamInterceptors...))
-	s.grpcServerOptions = append(s.grpcServerOptions, grpc_middleware.WithUnaryServerChain(s.unaryInterceptors...))
+	// install deadline interceptor in front of the others so that the timeout covers the whole chain
+	if s.defaultTimeout > 0 || len(s.methodTimeouts) > 0 {
+		keys := make([]string, 0, len(s.methodTimeouts))
+		for key := range s.methodTimeouts {
+			keys = append(keys, key)
+		}
+		s.timeoutMethods = newMethodMatcher(keys)
+		s.streamInterceptors = append([]grpc.StreamServerInterceptor{s.deadlineStreamInterceptor}, s.streamInterceptors...)
+		s.unaryInterceptors = append([]grpc.UnaryServerInterceptor{s.deadlineUnaryInterceptor}, s.unaryInterceptors...)
+	}
+
+	// install concurrency limit interceptor before authentication so that the excess load is shed early
+	if s.concurrencyLimiter != nil {
+		s.streamInterceptors = append(s.streamInterceptors, s.concurrencyLimiter.streamInterceptor)
+		s.unaryInterceptors = append(s.unaryInterceptors, s.concurrencyLimiter.unaryInterceptor)
+	}
+
+	// install authentication interceptor after the default ones so that rejected requests are still
+	// counted by prometheus and panics in authenticators are recovered
+	if len(s.authenticators) > 0 {
+		s.streamInterceptors = append(s.streamInterceptors, s.authStreamInterceptor)
+		s.unaryInterceptors = append(s.unaryInterceptors, s.authUnaryInterceptor)
+	}
+
+	// install authorization interceptor after authentication as it evaluates the claims
+	if s.policies != nil {
+		s.streamInterceptors = append(s.streamInterceptors, s.authzStreamInterceptor)
+		s.unaryInterceptors = append(s.unaryInterceptors, s.authzUnaryInterceptor)
+	}
+
+	// install rate limit interceptor after authentication so that the clients can be identified by claims
+	if s.rateLimiter != nil {
+		s.streamInterceptors = append(s.streamInterceptors, s.rateLimiter.streamInterceptor)
+		s.unaryInterceptors = append(s.unaryInterceptors, s.rateLimiter.unaryInterceptor)
+	}
 
+	// the in-flight requests are counted by the first interceptors of the chains
+	s.grpcServerOptions = append(s.grpcServerOptions, grpc_middleware.WithStreamServerChain(
+		append([]grpc.StreamServerInterceptor{s.inflightStreamInterceptor}, s.streamInterceptors...)...,
+	))
+	s.grpcServerOptions = append(s.grpcServerOptions, grpc_middleware.WithUnaryServerChain(
+		append([]grpc.UnaryServerInterceptor{s.inflightUnaryInterceptor}, s.unaryInterceptors...)...,
+	))
+
+	// the options of server config go first so that they can be overridden by GRPCServerOption
 	s.GRPCServer = grpc.NewServer(
-		s.grpcServerOptions...,
+		append(s.serverConfig.grpcServerOptions(), s.grpcServerOptions...)...,
 	)
 
+	if s.healthServer != nil {
+		healthpb.RegisterHealthServer(s.GRPCServer, s.healthServer)
+	}
+
 	if s.HTTPServer == nil {
 		s.HTTPServer = &http.Server{}
 	}
+	s.serverConfig.applyHTTPServer(s.HTTPServer)
 
-	return s
+	if s.HTTPServer.TLSConfig == nil {
+		s.HTTPServer.TLSConfig = s.tlsConfig
+	}
 }
 
 // Getpid gets the process id of server
@@ -152,11 +326,64 @@ func (s *Service) Getpid() int {
 }
 
 // Start starts the microservice with listening on the ports
-func (s *Service) Start(httpPort uint, grpcPort uint, reverseProxyFunc ReverseProxyFunc) error {
+func (s *Service) Start(httpPort uint, grpcPort uint, reverseProxyFunc ReverseProxyFunc) (err error) {
+	if s.isShutdown() {
+		return ErrServiceStopped
+	}
+
+	// run start hooks before listening, the stop hooks are run if Start fails after any start hook completed
+	// unless the shutdown has started which runs them
+	completed, err := s.runStartHooks()
+	defer func() {
+		if err != nil && completed > 0 && !s.isShutdown() {
+			if err := s.runStopHooks(); err != nil {
+				s.logger.Printf("%v", err)
+			}
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	// listen before serving so that the parent process in graceful restart is notified once both are ready
+	if _, err := s.listen(grpcListenerName, grpcPort); err != nil {
+		return err
+	}
+	if _, err := s.listen(httpListenerName, httpPort); err != nil {
+		s.closeListeners()
+		return err
+	}
+	s.setReady(true)
+	s.notifyParent()
+	registerService(s)
+	defer unregisterService(s)
+	if s.systemd {
+		s.notifySystemdReady()
+	}
+	s.startScheduler()
+	// the scheduler is stopped by the shutdown, it must be stopped as well if a server fails to serve
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		defer cancel()
+		if err := s.stopScheduler(ctx); err != nil {
+			s.log.logf(logLevelWarn, "%v", err)
+		}
+	}()
 
-	// intercept interrupt signals
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, s.interruptSignals...)
+	// intercept the signals to take their actions, nothing is intercepted if there is no signal to catch
+	// because signal.Notify without signals relays all of them
+	sigChan := make(chan os.Signal, len(s.signalActions))
+	if signals := s.signalsToNotify(); len(signals) > 0 {
+		signal.Notify(sigChan, signals...)
+		defer signal.Stop(sigChan)
+	}
+	signals := s.newSignalHandler()
+	defer signals.force()
+
+	// collect the methods before the gRPC server registers the reflection service
+	if s.connect {
+		s.connectMethods = connectMethods(s.GRPCServer)
+	}
 
 	// channels to receive error
 	errChan1 := make(chan error, 1)
@@ -174,21 +401,40 @@ func (s *Service) Start(httpPort uint, grpcPort uint, reverseProxyFunc ReversePr
 		errChan2 <- s.startGRPCGateway(httpPort, grpcPort, reverseProxyFunc)
 	}()
 
-	// wait for context cancellation or shutdown signal
-	select {
-	// if gRPC server fail to start
-	case err := <-errChan1:
-		return err
+	for {
+		// wait for context cancellation or shutdown signal
+		select {
+		// if gRPC server fail to start, it also stops once the drain sends GOAWAY
+		case err := <-errChan1:
+			if !s.stoppedByShutdown(err) {
+				return err
+			}
+			errChan1 = nil
 
-	// if http server fail to start
-	case err := <-errChan2:
-		return err
+		// if http server fail to start
+		case err := <-errChan2:
+			if !s.stoppedByShutdown(err) {
+				return err
+			}
+			errChan2 = nil
 
-	// if we received an interrupt signal
-	case sig := <-sigChan:
-		s.logger.Printf("Interrupt signal received: %v", sig)
-		s.Stop()
-		return nil
+		// if the shutdown finished, the signals are handled until then so that a second stop signal
+		// can still force it
+		case <-s.shutdownDone:
+			return s.shutdownErr
+
+		// if we received a signal
+		case sig := <-sigChan:
+			signals.handle(sig)
+
+		// if the restart started by a signal finished
+		case result := <-signals.restarted:
+			signals.finishRestart(result)
+
+		// if the shutdown started by a signal finished
+		case err := <-signals.done:
+			return err
+		}
 	}
 }
 
@@ -196,8 +442,7 @@ func (s *Service) startGRPCServer(grpcPort uint) error {
 	// register reflection service on gRPC server.
 	reflection.Register(s.GRPCServer)
 
-	grpcHost := fmt.Sprintf(":%d", grpcPort)
-	lis, err := net.Listen("tcp", grpcHost)
+	lis, err := s.listen(grpcListenerName, grpcPort)
 	if err != nil {
 		return err
 	}
@@ -206,21 +451,29 @@ func (s *Service) startGRPCServer(grpcPort uint) error {
 }
 
 func (s *Service) startGRPCGateway(httpPort uint, grpcPort uint, reverseProxyFunc ReverseProxyFunc) error {
+	// the redoc options can be replaced by reloading concurrently, they are read and replaced under the lock
+	s.redocMu.Lock()
 	if s.redoc.Up {
-		s.redoc.EnsureDefaults()
+		defaults := *s.redoc
+		defaults.EnsureDefaults()
+		s.redoc = &defaults
+	}
+	redoc := s.redoc
+	s.redocMu.Unlock()
 
+	if redoc.Up {
 		// add redoc endpoint for api docs
 		routeDocs := Route{
 			Method: "GET",
-			Path:   s.redoc.Route,
+			Path:   redoc.Route,
 			Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-				s.redoc.Serve(w, r, pathParams)
+				s.currentRedoc().Serve(w, r, pathParams)
 			},
 		}
 		s.AddRoutes(routeDocs)
 
 		// host local spec files if not set yet
-		for _, url := range s.redoc.SpecURLs {
+		for _, url := range redoc.SpecURLs {
 			if strings.HasPrefix(url, "/") {
 				fileRoute := Route{
 					Method:  "GET",
@@ -234,54 +487,181 @@ func (s *Service) startGRPCGateway(httpPort uint, grpcPort uint, reverseProxyFun
 		}
 	}
 
-	err := reverseProxyFunc(context.Background(), s.mux, fmt.Sprintf("localhost:%d", grpcPort), s.grpcDialOptions)
+	dialOptions := append(s.serverConfig.grpcDialOptions(), s.grpcDialOptions...)
+	err := reverseProxyFunc(context.Background(), s.mux, fmt.Sprintf("localhost:%d", grpcPort), dialOptions)
 	if err != nil {
 		return err
 	}
 
 	// apply routes
-	for _, route := range s.routes {
-		s.mux.HandlePath(route.Method, route.Path, route.Handler)
+	s.routesMu.RLock()
+	routes := s.routes
+	s.routesMu.RUnlock()
+	for _, route := range routes {
+		// the rate limits are inside the authorization so that the clients can be identified by claims
+		handler := route.Handler
+		if s.rateLimiter != nil {
+			handler = s.rateLimiter.limitRoute(route, handler)
+		}
+		if s.policies != nil {
+			handler = s.authorizeRoute(route, handler)
+		}
+		s.mux.HandlePath(route.Method, route.Path, handler)
 	}
 
 	s.HTTPServer.Addr = fmt.Sprintf(":%d", httpPort)
-	s.HTTPServer.Handler = s.httpHandler(s.mux)
+	// add the readiness endpoint if not set yet
+	readinessRoute := Route{
+		Method:  "GET",
+		Path:    readinessPath,
+		Handler: s.readinessHandler,
+	}
+	if !s.HasRoute(readinessRoute) {
+		s.mux.HandlePath(readinessRoute.Method, readinessRoute.Path, readinessRoute.Handler)
+	}
+
+	handler := s.httpHandler(s.mux)
+	if s.webSocket != nil {
+		handler = s.webSocketMiddleware(handler)
+	}
+	if s.sse != nil {
+		handler = s.sseMiddleware(handler)
+	}
+	if s.grpcHTTP != nil {
+		handler = s.grpcHTTPMiddleware(handler)
+	}
+	s.HTTPServer.Handler = s.serverConfig.limitBody(handler)
+	if s.concurrencyLimiter != nil {
+		s.HTTPServer.Handler = s.concurrencyLimiter.middleware(s.HTTPServer.Handler)
+	}
+	// the preflight requests are responded before being counted by the concurrency limit
+	if s.cors != nil {
+		s.HTTPServer.Handler = s.cors.middleware(s.HTTPServer.Handler)
+	}
+	s.HTTPServer.Handler = s.inflightMiddleware(s.HTTPServer.Handler)
 	s.HTTPServer.RegisterOnShutdown(s.shutdownFunc)
 
-	return s.HTTPServer.ListenAndServe()
+	lis, err := s.listen(httpListenerName, httpPort)
+	if err != nil {
+		return err
+	}
+
+	// serve https if the tls config is provided, the certificates should be set in the tls config
+	if s.HTTPServer.TLSConfig != nil {
+		return s.HTTPServer.ServeTLS(lis, "", "")
+	}
+
+	return s.HTTPServer.Serve(lis)
 }
 
-// Stop stops the microservice gracefully
+// stoppedByShutdown checks if the server stopped serving because of Shutdown
+func (s *Service) stoppedByShutdown(err error) bool {
+	return s.isShutdown() && (err == nil || err == http.ErrServerClosed || err == grpc.ErrServerStopped)
+}
+
+// isShutdown checks if Shutdown has been called
+func (s *Service) isShutdown() bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+
+	return s.shutdownStarted
+}
+
+// Stop stops the microservice gracefully, the errors are logged, see Shutdown
 func (s *Service) Stop() {
-	// disable keep-alives on existing connections
-	s.HTTPServer.SetKeepAlivesEnabled(false)
+	s.Shutdown(context.Background())
+}
 
-	// we wait for a duration of preShutdownDelay for running goroutines to finish their jobs
-	if s.preShutdownDelay > 0 {
-		s.logger.Printf("Waiting for %v before shutdown starts", s.preShutdownDelay)
-		time.Sleep(s.preShutdownDelay)
+// Shutdown stops the microservice gracefully within the shutdownTimeout or the deadline of the ctx whichever
+// is earlier, the servers are stopped forcibly if the deadline is exceeded and the returned error reports
+// them. It is safe to be called multiple times and concurrently, the later calls wait for the first one and
+// return its result unless their ctx is done
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.shutdownMu.Lock()
+	started := s.shutdownStarted
+	s.shutdownStarted = true
+	s.shutdownMu.Unlock()
+
+	if !started {
+		s.shutdownErr = s.shutdown(ctx)
+		close(s.shutdownDone)
+		return s.shutdownErr
 	}
 
-	// gracefully stop gRPC server first
-	s.GRPCServer.GracefulStop()
+	select {
+	case <-s.shutdownDone:
+		return s.shutdownErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	var ctx, cancel = context.WithTimeout(
-		context.Background(),
-		s.shutdownTimeout,
-	)
+func (s *Service) shutdown(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.shutdownTimeout)
 	defer cancel()
 
-	// gracefully stop http server
-	s.HTTPServer.Shutdown(ctx)
+	if s.systemd {
+		if err := sdNotify("STOPPING=1"); err != nil {
+			s.log.logf(logLevelWarn, "Failed to notify systemd: %v", err)
+		}
+	}
+
+	// we wait for at most preShutdownDelay for the in-flight requests to finish
+	s.drain(ctx)
+
+	var errs MultiError
+
+	// wait for the gRPC server to stop gracefully first, the pending RPCs are cancelled if the deadline is
+	// exceeded
+	var stopped <-chan struct{}
+	// the gRPC-Web, Connect, WebSocket and SSE calls are cancelled before since GracefulStop can not drain the calls
+	// served over http, the gRPC server is stopped forcibly if they do not return before the deadline
+	if s.grpcHTTP == nil || s.grpcHTTP.close(ctx) == nil {
+		stopped = s.gracefulStopGRPC()
+	}
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.GRPCServer.Stop()
+		errs = append(errs, fmt.Errorf("gRPC server is stopped forcibly: %v", ctx.Err()))
+	}
+
+	// gracefully stop http server, the remaining connections are closed if the deadline is exceeded
+	if err := s.HTTPServer.Shutdown(ctx); err != nil {
+		s.HTTPServer.Close()
+		errs = append(errs, fmt.Errorf("http server is closed forcibly: %v", err))
+	}
+
+	// cancel the scheduled jobs after the servers stop so that the jobs do not race with the requests
+	if err := s.stopScheduler(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	// run stop hooks after the servers are drained
+	if err := s.runStopHooks(); err != nil {
+		errs = append(errs, err.(MultiError)...)
+	}
+
+	for _, err := range errs {
+		s.logger.Printf("%v", err)
+	}
+
+	return errs.errorOrNil()
 }
 
 // AddRoutes adds additional routes
 func (s *Service) AddRoutes(routes ...Route) {
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+
 	s.routes = append(s.routes, routes...)
 }
 
-// HasRoute checks if a route already exists
+// HasRoute checks if a route already exists, it is safe to be called while the service starts
 func (s *Service) HasRoute(route Route) bool {
+	s.routesMu.RLock()
+	defer s.routesMu.RUnlock()
+
 	for _, r := range s.routes {
 		if r.Method == route.Method && r.Path == route.Path {
 			return true
@@ -194,6 +194,45 @@ func TestNewService(t *testing.T) {
 	time.Sleep(3 * time.Second)
 }
 
+func TestNew(t *testing.T) {
+	var should = require.New(t)
+
+	s, err := New(StaticDir("/a/b/c"))
+	should.NoError(err)
+	should.Equal("/a/b/c", s.staticDir)
+	should.NotNil(s.GRPCServer)
+	should.NotNil(s.HTTPServer)
+
+	// the errors of the options
+	_, err = New(ShutdownTimeout(-time.Second))
+	should.EqualError(err, "shutdown timeout must not be negative")
+
+	_, err = New(RouteOpt(Route{Method: "GET", Path: "test"}))
+	should.EqualError(err, "route path should start with /: test")
+
+	_, err = New(LogLevel("verbose"))
+	should.EqualError(err, "invalid log level: verbose")
+
+	// the invalid final configuration
+	_, err = New(Redoc(&RedocOpts{Route: "docs", Up: true}))
+	should.EqualError(err, "invalid redoc options: route should start with /: docs")
+
+	config := DefaultServerConfig()
+	config.MaxRecvMsgSize = 0
+	_, err = New(WithServerConfig(config))
+	should.EqualError(err, "invalid server config: max_recv_msg_size must be positive")
+
+	// the errors of all the failed options are returned
+	_, err = New(ShutdownTimeout(-time.Second), StaticDir("/a/b/c"), LogLevel("verbose"))
+	should.EqualError(err, "shutdown timeout must not be negative; invalid log level: verbose")
+	should.Len(err, 2)
+
+	// NewService panics on the failed options
+	should.PanicsWithError("shutdown timeout must not be negative", func() {
+		NewService(ShutdownTimeout(-time.Second), StaticDir("/a/b/c"))
+	})
+}
+
 func TestErrorReverseProxyFunc(t *testing.T) {
 	var should = require.New(t)
 
@@ -1,143 +1,597 @@
 package micro
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/metadata"
 )
 
 // Option is service functional option
 //
 // See this post about the "functional options" pattern:
 // http://dave.cheney.net/2014/10/17/functional-options-for-friendly-apis
-type Option func(s *Service)
+type Option func(s *Service) error
 
 // StaticDir returns an Option to set the staticDir
 func StaticDir(staticDir string) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
 		s.staticDir = staticDir
+		return nil
 	}
 }
 
 // Redoc returns an Option to set the Redoc
 func Redoc(redoc *RedocOpts) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
+		if redoc == nil {
+			return errors.New("redoc options must not be nil")
+		}
+
 		s.redoc = redoc
+
+		return nil
 	}
 }
 
 // Annotator returns an Option to append an annotator
 func Annotator(annotator AnnotatorFunc) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
+		if annotator == nil {
+			return errors.New("annotator must not be nil")
+		}
+
 		s.annotators = append(s.annotators, annotator)
+
+		return nil
 	}
 }
 
 // ErrorHandler returns an Option to set the errorHandler
 func ErrorHandler(errorHandler runtime.ErrorHandlerFunc) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
+		if errorHandler == nil {
+			return errors.New("error handler must not be nil")
+		}
+
 		s.errorHandler = errorHandler
+
+		return nil
 	}
 }
 
+// ProblemJSON returns an Option to render the gateway errors as RFC 7807 application/problem+json, it is the
+// same as ErrorHandler(ProblemJSONErrorHandler)
+func ProblemJSON() Option {
+	return ErrorHandler(ProblemJSONErrorHandler)
+}
+
 // HTTPHandler returns an Option to set the httpHandler
 func HTTPHandler(httpHandler HTTPHandlerFunc) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
+		if httpHandler == nil {
+			return errors.New("http handler must not be nil")
+		}
+
 		s.httpHandler = httpHandler
+
+		return nil
 	}
 }
 
 // UnaryInterceptor returns an Option to append an unaryInterceptor
 func UnaryInterceptor(unaryInterceptor grpc.UnaryServerInterceptor) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
+		if unaryInterceptor == nil {
+			return errors.New("unary interceptor must not be nil")
+		}
+
 		s.unaryInterceptors = append(s.unaryInterceptors, unaryInterceptor)
+
+		return nil
 	}
 }
 
 // StreamInterceptor returns an Option to append an streamInterceptor
 func StreamInterceptor(streamInterceptor grpc.StreamServerInterceptor) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
+		if streamInterceptor == nil {
+			return errors.New("stream interceptor must not be nil")
+		}
+
 		s.streamInterceptors = append(s.streamInterceptors, streamInterceptor)
+
+		return nil
 	}
 }
 
 // RouteOpt returns an Option to append a route
 func RouteOpt(route Route) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
+		if err := route.validate(); err != nil {
+			return err
+		}
+
 		s.routes = append(s.routes, route)
+
+		return nil
 	}
 }
 
-// ShutdownFunc returns an Option to register a function which will be called when server shutdown
+// ShutdownFunc returns an Option to register a function which will be called when server shutdown, it runs
+// concurrently with the http server shutdown, see OnStop for the ordered hooks
 func ShutdownFunc(f func()) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
+		if f == nil {
+			return errors.New("shutdown function must not be nil")
+		}
+
 		s.shutdownFunc = f
+
+		return nil
+	}
+}
+
+// OnStart returns an Option to append a hook which is called by Start before the servers accept traffic,
+// the hooks are called in order and Start fails at the first error, the timeout of the hook defaults to 10s.
+// The stop hooks are called if Start fails after any start hook completed
+func OnStart(hook Hook, timeout time.Duration) Option {
+	return func(s *Service) error {
+		if hook == nil {
+			return errors.New("start hook must not be nil")
+		}
+
+		s.startHooks = append(s.startHooks, lifecycleHook{hook: hook, timeout: timeout})
+
+		return nil
+	}
+}
+
+// OnStop returns an Option to append a hook which is called after the servers are drained, the hooks
+// are called in reverse order and all of them are called even if some fail, the errors are logged and
+// returned by Shutdown. Each hook has its own timeout which defaults to 10s
+func OnStop(hook Hook, timeout time.Duration) Option {
+	return func(s *Service) error {
+		if hook == nil {
+			return errors.New("stop hook must not be nil")
+		}
+
+		s.stopHooks = append(s.stopHooks, lifecycleHook{hook: hook, timeout: timeout})
+
+		return nil
+	}
+}
+
+// Name returns an Option to set the name of the service which labels its metrics, so that the services in
+// the same process are told apart
+func Name(name string) Option {
+	return func(s *Service) error {
+		s.name = name
+
+		return nil
 	}
 }
 
 // ShutdownTimeout returns an Option to set the timeout before the server shutdown abruptly
 func ShutdownTimeout(timeout time.Duration) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
+		if timeout < 0 {
+			return errors.New("shutdown timeout must not be negative")
+		}
+
 		s.shutdownTimeout = timeout
+
+		return nil
 	}
 }
 
-// PreShutdownDelay returns an Option to set the time waiting for running goroutines
-// to finish their jobs before the shutdown starts
+// PreShutdownDelay returns an Option to set the maximum time waiting for the in-flight requests
+// to finish before the shutdown starts, the service is marked not ready during the delay
 func PreShutdownDelay(timeout time.Duration) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
+		if timeout < 0 {
+			return errors.New("pre-shutdown delay must not be negative")
+		}
+
 		s.preShutdownDelay = timeout
+
+		return nil
 	}
 }
 
-// InterruptSignal returns an Option to append a interrupt signal
+// DefaultTimeout returns an Option to set the server-side timeout of the gRPC requests including the
+// ones forwarded by the gateway, the earlier deadline sent by the client is kept, the gateway responds
+// 504 if the deadline is exceeded
+func DefaultTimeout(timeout time.Duration) Option {
+	return func(s *Service) error {
+		if timeout < 0 {
+			return errors.New("default timeout must not be negative")
+		}
+
+		s.defaultTimeout = timeout
+
+		return nil
+	}
+}
+
+// MethodTimeout returns an Option to override the server-side timeout of the method, the method is the
+// full method name like /package.Service/Method, path.Match patterns like /package.Service/* are supported
+func MethodTimeout(method string, timeout time.Duration) Option {
+	return func(s *Service) error {
+		if timeout < 0 {
+			return fmt.Errorf("timeout of %s must not be negative", method)
+		}
+		if s.methodTimeouts == nil {
+			s.methodTimeouts = make(map[string]time.Duration)
+		}
+		s.methodTimeouts[method] = timeout
+
+		return nil
+	}
+}
+
+// InterruptSignal returns an Option to append a interrupt signal, it is the same as Signal with SignalStop
 func InterruptSignal(signal os.Signal) Option {
-	return func(s *Service) {
-		s.interruptSignals = append(s.interruptSignals, signal)
+	return Signal(signal, SignalStop)
+}
+
+// Signal returns an Option to take the action on the signal, it replaces the action of the signal if any.
+// SIGKILL and SIGSTOP can not be caught
+func Signal(signal os.Signal, action SignalAction) Option {
+	return func(s *Service) error {
+		if err := validateSignal(signal, action); err != nil {
+			return err
+		}
+
+		s.signalActions[signal] = action
+
+		return nil
+	}
+}
+
+// Signals returns an Option to replace all the signals to catch and their actions, by default
+// InterruptSignals stop the service and ReloadSignals reload the config
+func Signals(actions map[os.Signal]SignalAction) Option {
+	return func(s *Service) error {
+		if len(actions) == 0 {
+			return fmt.Errorf("signals must not be empty")
+		}
+
+		signalActions := make(map[os.Signal]SignalAction)
+		for signal, action := range actions {
+			if err := validateSignal(signal, action); err != nil {
+				return err
+			}
+			signalActions[signal] = action
+		}
+		s.signalActions = signalActions
+
+		return nil
+	}
+}
+
+// GracefulRestart returns an Option to enable the graceful restart on the signal such as SIGUSR2, the
+// service starts the same executable with the same arguments and passes the listeners of all the services
+// in the process to the new process, then it stops gracefully once all the services of the new process are
+// ready. The services restarting on the same signal share the one new process. The new process is killed and the service keeps running if the new process is not ready within the
+// timeout which defaults to 30s
+func GracefulRestart(signal os.Signal, timeout time.Duration) Option {
+	return func(s *Service) error {
+		if err := validateSignal(signal, SignalRestart); err != nil {
+			return err
+		}
+
+		s.signalActions[signal] = SignalRestart
+		if timeout > 0 {
+			s.restartTimeout = timeout
+		}
+
+		return nil
+	}
+}
+
+// GRPCWeb returns an Option to accept the gRPC-Web calls on the http server, they are served by GRPCServer
+// with its interceptors. Use the CORS option to allow the browsers on other origins
+func GRPCWeb() Option {
+	return func(s *Service) error {
+		s.grpcWeb = true
+		if s.grpcHTTP == nil {
+			s.grpcHTTP = newGRPCHTTPCalls()
+		}
+
+		return nil
+	}
+}
+
+// Connect returns an Option to accept the Connect protocol calls on the http server, they are served by the
+// services registered on GRPCServer without extra code generation
+func Connect() Option {
+	return func(s *Service) error {
+		s.connect = true
+		if s.grpcHTTP == nil {
+			s.grpcHTTP = newGRPCHTTPCalls()
+		}
+
+		return nil
+	}
+}
+
+// WebSocket returns an Option to bridge the WebSocket connections to the gateway routes so that the browsers
+// can call the client and bidirectional streaming methods, see WebSocketOpts for the protocol
+func WebSocket(opts WebSocketOpts) Option {
+	return func(s *Service) error {
+		if opts.PingInterval < 0 || opts.PongTimeout < 0 {
+			return errors.New("websocket ping interval and pong timeout must not be negative")
+		}
+		opts.ensureDefaults()
+		s.webSocket = &opts
+		if s.grpcHTTP == nil {
+			s.grpcHTTP = newGRPCHTTPCalls()
+		}
+
+		return nil
+	}
+}
+
+// SSE returns an Option to respond the requests accepting text/event-stream as Server-Sent Events so that the
+// browsers can consume the server streaming methods by EventSource, see SSEOpts for the events
+func SSE(opts SSEOpts) Option {
+	return func(s *Service) error {
+		if opts.HeartbeatInterval < 0 {
+			return errors.New("sse heartbeat interval must not be negative")
+		}
+		opts.ensureDefaults()
+		s.sse = &opts
+		s.annotators = append(s.annotators, lastEventIDAnnotator)
+		if s.grpcHTTP == nil {
+			s.grpcHTTP = newGRPCHTTPCalls()
+		}
+
+		return nil
+	}
+}
+
+// ScheduleJob returns an Option to run the job by the schedule while the service is running, the job starts
+// once the service is ready and its ctx is cancelled on shutdown after the servers stop, see Scheduler
+func ScheduleJob(opts JobOpts, job Job) Option {
+	return func(s *Service) error {
+		if s.scheduler == nil {
+			s.scheduler = NewScheduler()
+		}
+
+		return s.scheduler.Add(opts, job)
+	}
+}
+
+// Systemd returns an Option to run under systemd, the listeners passed by socket activation with the
+// FileDescriptorName= grpc and http are used instead of listening on the ports, and READY=1, STOPPING=1 and
+// WATCHDOG=1 are notified over NOTIFY_SOCKET with the startup and shutdown. Both are skipped if the
+// service is not started by systemd
+func Systemd() Option {
+	return func(s *Service) error {
+		s.systemd = true
+		return nil
 	}
 }
 
 // GRPCServerOption returns an Option to append a gRPC server option
 func GRPCServerOption(serverOption grpc.ServerOption) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
 		s.grpcServerOptions = append(s.grpcServerOptions, serverOption)
+		return nil
 	}
 }
 
 // GRPCDialOption returns an Option to append a gRPC dial option
 func GRPCDialOption(dialOption grpc.DialOption) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
 		s.grpcDialOptions = append(s.grpcDialOptions, dialOption)
+		return nil
+	}
+}
+
+// WithOutgoingHeaderMatcher returns an Option to set the matcher of the metadata sent as the http headers by
+// the gateway, the headers of the other options such as Retry-After of RateLimit are kept
+func WithOutgoingHeaderMatcher(matcher runtime.HeaderMatcherFunc) Option {
+	return func(s *Service) error {
+		if matcher == nil {
+			return errors.New("outgoing header matcher must not be nil")
+		}
+
+		s.outgoingHeaderMatcher = matcher
+
+		return nil
 	}
 }
 
 // MuxOption returns an Option to append a mux option
 func MuxOption(muxOption runtime.ServeMuxOption) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
 		s.muxOptions = append(s.muxOptions, muxOption)
+		return nil
 	}
 }
 
 // WithHTTPServer returns an Option to set the http server, note that the Addr and Handler will be
-// reset in startGRPCGateway(), so you are not able to specify them
+// reset in startGRPCGateway(), so you are not able to specify them. The timeouts and MaxHeaderBytes
+// which are not set are taken from the ServerConfig. If the TLSConfig is set, the server will serve
+// https with the certificates in the TLSConfig
 func WithHTTPServer(server *http.Server) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
 		s.HTTPServer = server
+		return nil
+	}
+}
+
+// TLS returns an Option to serve both gRPC and http with the server tls config, the client tls config
+// is used by the gateway to dial the gRPC server. The http server keeps its own TLSConfig if it is set
+// by WithHTTPServer. See NewDevCertificates for generating certificates in development and test
+func TLS(server *tls.Config, client *tls.Config) Option {
+	return func(s *Service) error {
+		if server == nil || client == nil {
+			return errors.New("tls configs must not be nil")
+		}
+		s.tlsConfig = server
+		s.grpcServerOptions = append(s.grpcServerOptions, grpc.Creds(credentials.NewTLS(server)))
+		s.grpcDialOptions = append(s.grpcDialOptions, grpc.WithTransportCredentials(credentials.NewTLS(client)))
+
+		return nil
+	}
+}
+
+// ClientIdentity returns an Option to extract the identity of the verified client certificate into the
+// context, the identity is forwarded by the gateway as well if the http server is using mutual tls,
+// the authorizer is called with the identity for each request and can be nil
+func ClientIdentity(authorizer IdentityAuthorizer) Option {
+	return func(s *Service) error {
+		s.unaryInterceptors = append(s.unaryInterceptors, s.identityUnaryInterceptor(authorizer))
+		s.streamInterceptors = append(s.streamInterceptors, s.identityStreamInterceptor(authorizer))
+		s.annotators = append(s.annotators, s.identityAnnotator)
+		// the gateway is told apart from the clients by the forwarded client ip
+		s.forwardClientIP()
+
+		return nil
+	}
+}
+
+// Authentication returns an Option to append an authenticator, the authenticators are tried in order
+// for each gRPC request including the ones forwarded by the gateway, see JWTAuthenticator and
+// APIKeyAuthenticator. If the authenticator has the method Annotate(context.Context, *http.Request) metadata.MD,
+// it is installed as an annotator as well
+func Authentication(authenticator Authenticator) Option {
+	return func(s *Service) error {
+		if authenticator == nil {
+			return errors.New("authenticator must not be nil")
+		}
+		s.authenticators = append(s.authenticators, authenticator)
+		if a, ok := authenticator.(interface {
+			Annotate(context.Context, *http.Request) metadata.MD
+		}); ok {
+			s.annotators = append(s.annotators, a.Annotate)
+		}
+
+		return nil
+	}
+}
+
+// Authorization returns an Option to set the authorization policies, the gRPC method policies are
+// evaluated after authentication and the route policies are evaluated for the routes added by RouteOpt
+// or AddRoutes, see LoadPolicies
+func Authorization(policies *Policies) Option {
+	return func(s *Service) error {
+		if policies == nil {
+			return errors.New("policies must not be nil")
+		}
+
+		s.policies = policies
+		s.policies.methods = policies.methodMatcher()
+
+		return nil
+	}
+}
+
+// RateLimit returns an Option to enable rate limiting, the gRPC requests including the ones forwarded
+// by the gateway are rejected with ResourceExhausted and the http clients receive 429 with Retry-After.
+// Use WithOutgoingHeaderMatcher rather than MuxOption to keep Retry-After with a custom header matcher
+func RateLimit(opts RateLimitOpts) Option {
+	return func(s *Service) error {
+		s.rateLimiter = newRateLimiter(s, opts)
+		s.forwardClientIP()
+
+		return nil
+	}
+}
+
+// ConcurrencyLimit returns an Option to cap the in-flight requests, the excess gRPC requests are rejected
+// with Unavailable and the http requests are rejected with 503
+func ConcurrencyLimit(opts ConcurrencyLimitOpts) Option {
+	return func(s *Service) error {
+		if opts.Limit < 0 {
+			return errors.New("concurrency limit must not be negative")
+		}
+		s.concurrencyLimiter = newConcurrencyLimiter(s, opts)
+		s.forwardClientIP()
+
+		return nil
+	}
+}
+
+// WithServerConfig returns an Option to set the connection and size limits of both servers, it
+// replaces DefaultServerConfig and is validated when the service is created
+func WithServerConfig(config ServerConfig) Option {
+	return func(s *Service) error {
+		s.serverConfig = config
+		return nil
+	}
+}
+
+// CORS returns an Option to allow the cross-origin requests from the origins to the http server, the
+// preflight requests are responded directly, "*" allows any origin
+func CORS(origins ...string) Option {
+	return func(s *Service) error {
+		s.cors = newCORS(origins)
+		return nil
+	}
+}
+
+// Health returns an Option to register the gRPC health server which reports NOT_SERVING as soon as
+// the shutdown starts, the http readiness endpoint /ready is always served
+func Health(server *health.Server) Option {
+	return func(s *Service) error {
+		if server == nil {
+			return errors.New("health server must not be nil")
+		}
+
+		s.healthServer = server
+
+		return nil
 	}
 }
 
 // WithLogger uses the provided logger
 func WithLogger(logger Logger) Option {
-	return func(s *Service) {
+	return func(s *Service) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+
 		s.logger = logger
+
+		return nil
+	}
+}
+
+// LogLevel returns an Option to set the log level which is one of debug, info and warn, default is info
+func LogLevel(level string) Option {
+	return func(s *Service) error {
+		if _, ok := logLevels[level]; !ok {
+			return fmt.Errorf("invalid log level: %s", level)
+		}
+
+		s.logLevel = level
+
+		return nil
 	}
 }
 
-func (s *Service) apply(opts ...Option) {
+// apply applies the options in order, all the options are applied even if some of them fail and the
+// errors are returned
+func (s *Service) apply(opts ...Option) MultiError {
+	var errs MultiError
 	for _, opt := range opts {
-		opt(s)
+		if err := opt(s); err != nil {
+			errs = append(errs, err)
+		}
 	}
+
+	return errs
 }
@@ -32,13 +32,15 @@ func TestAnnotator(t *testing.T) {
 }
 
 func TestErrorHandler(t *testing.T) {
-	s := NewService(ErrorHandler(nil))
-	assert.Nil(t, s.errorHandler)
+	_, err := New(ErrorHandler(nil))
+	assert.EqualError(t, err, "error handler must not be nil")
+	assert.PanicsWithError(t, "error handler must not be nil", func() { NewService(ErrorHandler(nil)) })
 }
 
 func TestHTTPHandler(t *testing.T) {
-	s := NewService(HTTPHandler(nil))
-	assert.Nil(t, s.httpHandler)
+	_, err := New(HTTPHandler(nil))
+	assert.EqualError(t, err, "http handler must not be nil")
+	assert.PanicsWithError(t, "http handler must not be nil", func() { NewService(HTTPHandler(nil)) })
 }
 
 func TestUnaryInterceptor(t *testing.T) {
@@ -63,10 +65,15 @@ func TestStreamInterceptor(t *testing.T) {
 
 func TestInterruptSignal(t *testing.T) {
 	s := NewService(
-		InterruptSignal(syscall.SIGKILL),
+		InterruptSignal(syscall.SIGUSR1),
 	)
 
-	assert.Len(t, s.interruptSignals, 5)
+	assert.Len(t, s.signalActions, 5)
+	assert.Equal(t, SignalStop, s.signalActions[syscall.SIGUSR1])
+
+	// uncatchable signals are invalid
+	_, err := New(InterruptSignal(syscall.SIGKILL))
+	assert.EqualError(t, err, "signal killed can not be caught")
 }
 
 func TestGRPCServerOption(t *testing.T) {
@@ -114,3 +121,33 @@ func TestMuxOption(t *testing.T) {
 
 	assert.Len(t, s.muxOptions, 2)
 }
+
+func TestClientIdentity(t *testing.T) {
+	s := NewService(ClientIdentity(nil))
+
+	assert.Len(t, s.unaryInterceptors, 4)
+	assert.Len(t, s.streamInterceptors, 4)
+	assert.Len(t, s.annotators, 2)
+}
+
+func TestTLS(t *testing.T) {
+	certs, err := NewDevCertificates()
+	assert.NoError(t, err)
+
+	s := NewService(TLS(certs.ServerTLSConfig(false), certs.ClientTLSConfig()))
+
+	assert.Len(t, s.grpcServerOptions, 3)
+	assert.Len(t, s.grpcDialOptions, 1)
+	assert.NotNil(t, s.HTTPServer.TLSConfig)
+}
+
+func TestAuthentication(t *testing.T) {
+	s := NewService(
+		Authentication(AuthenticatorFunc(func(ctx context.Context, fullMethod string) (context.Context, error) {
+			return ctx, nil
+		})),
+	)
+
+	assert.Len(t, s.authenticators, 1)
+	assert.Len(t, s.unaryInterceptors, 4)
+}
@@ -0,0 +1,250 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is the authorization policy of a gRPC method or a http route
+type Policy struct {
+	// Roles are the roles which are allowed, the claims should contain at least one of them
+	Roles []string `yaml:"roles" json:"roles"`
+	// Scopes are the scopes which are required, the claims should contain all of them
+	Scopes []string `yaml:"scopes" json:"scopes"`
+}
+
+// Policies maps the gRPC methods and http routes to the authorization policies, the methods
+// and routes without policy are allowed
+type Policies struct {
+	// Methods are the policies of gRPC methods, the key is the full method name like
+	// /package.Service/Method, path.Match patterns like /package.Service/* are supported
+	Methods map[string]Policy `yaml:"methods" json:"methods"`
+	// Routes are the policies of the http routes, the key is the route path like /docs,
+	// or the http method and path separated by a space like "GET /docs"
+	Routes map[string]Policy `yaml:"routes" json:"routes"`
+	// RolesClaim is the name of the roles claim, default is roles
+	RolesClaim string `yaml:"roles_claim" json:"roles_claim"`
+	// ScopesClaim is the name of the scopes claim, default is scope
+	ScopesClaim string `yaml:"scopes_claim" json:"scopes_claim"`
+
+	// methods matches the Methods, it is built by Authorization and reset once Methods are added
+	methods *methodMatcher
+}
+
+// LoadPolicies loads the policies from a YAML or JSON file
+func LoadPolicies(file string) (*Policies, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies Policies
+	if err := yaml.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("policies %s: %v", file, err)
+	}
+
+	return &policies, nil
+}
+
+// LoadProto adds the policies declared as custom method options in the registered proto files, the
+// extension should be a message with the repeated string fields roles and scopes, for example:
+//
+//	extend google.protobuf.MethodOptions {
+//	  Policy policy = 50000;
+//	}
+//	message Policy {
+//	  repeated string roles = 1;
+//	  repeated string scopes = 2;
+//	}
+func (p *Policies) LoadProto(ext protoreflect.ExtensionType) error {
+	if ext.TypeDescriptor().Message() == nil {
+		return fmt.Errorf("extension %s is not a message", ext.TypeDescriptor().FullName())
+	}
+
+	protoregistry.GlobalFiles.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		services := file.Services()
+		for i := 0; i < services.Len(); i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				method := methods.Get(j)
+				opts := method.Options()
+				if opts == nil || !proto.HasExtension(opts, ext) {
+					continue
+				}
+
+				msg := proto.GetExtension(opts, ext).(proto.Message).ProtoReflect()
+				fullMethod := fmt.Sprintf("/%s/%s", services.Get(i).FullName(), method.Name())
+				p.addMethod(fullMethod, Policy{
+					Roles:  protoStrings(msg, "roles"),
+					Scopes: protoStrings(msg, "scopes"),
+				})
+			}
+		}
+		return true
+	})
+
+	return nil
+}
+
+func (p *Policies) addMethod(fullMethod string, policy Policy) {
+	if p.Methods == nil {
+		p.Methods = make(map[string]Policy)
+	}
+
+	p.Methods[fullMethod] = policy
+	p.methods = nil
+}
+
+// methodMatcher returns the matcher of the Methods
+func (p *Policies) methodMatcher() *methodMatcher {
+	if p.methods != nil {
+		return p.methods
+	}
+
+	keys := make([]string, 0, len(p.Methods))
+	for key := range p.Methods {
+		keys = append(keys, key)
+	}
+
+	return newMethodMatcher(keys)
+}
+
+func protoStrings(msg protoreflect.Message, name protoreflect.Name) []string {
+	field := msg.Descriptor().Fields().ByName(name)
+	if field == nil || !field.IsList() || field.Kind() != protoreflect.StringKind {
+		return nil
+	}
+
+	list := msg.Get(field).List()
+	values := make([]string, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		values = append(values, list.Get(i).String())
+	}
+
+	return values
+}
+
+// MethodPolicy returns the policy of the gRPC method, the exact match takes precedence over patterns and
+// the pattern with the longest literal prefix takes precedence over the others
+func (p *Policies) MethodPolicy(fullMethod string) (Policy, bool) {
+	key, ok := p.methodMatcher().match(fullMethod)
+	if !ok {
+		return Policy{}, false
+	}
+
+	return p.Methods[key], true
+}
+
+// RoutePolicy returns the policy of the http route, the one with http method takes precedence
+func (p *Policies) RoutePolicy(method, path string) (Policy, bool) {
+	if policy, ok := p.Routes[method+" "+path]; ok {
+		return policy, true
+	}
+
+	policy, ok := p.Routes[path]
+	return policy, ok
+}
+
+// Evaluate evaluates the policy against the claims in the context
+func (p *Policies) Evaluate(ctx context.Context, policy Policy) error {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "permission denied: no claims")
+	}
+
+	if len(policy.Roles) > 0 && !containsAny(claims.Strings(p.claimName(p.RolesClaim, "roles")), policy.Roles) {
+		return status.Errorf(codes.PermissionDenied, "permission denied: one of roles %s is required",
+			strings.Join(policy.Roles, ", "))
+	}
+
+	scopes := claims.Strings(p.claimName(p.ScopesClaim, "scope"))
+	for _, scope := range policy.Scopes {
+		if !containsAny(scopes, []string{scope}) {
+			return status.Errorf(codes.PermissionDenied, "permission denied: scope %s is required", scope)
+		}
+	}
+
+	return nil
+}
+
+func (p *Policies) claimName(name, defaultName string) string {
+	if name == "" {
+		return defaultName
+	}
+
+	return name
+}
+
+func containsAny(values []string, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *Service) authorize(ctx context.Context, fullMethod string) error {
+	policy, ok := s.policies.MethodPolicy(fullMethod)
+	if !ok {
+		return nil
+	}
+
+	return s.policies.Evaluate(ctx, policy)
+}
+
+func (s *Service) authzUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (s *Service) authzStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(srv, stream)
+}
+
+// authorizeRoute wraps the handler of the route with the authentication and the route policy
+func (s *Service) authorizeRoute(route Route, handler runtime.HandlerFunc) runtime.HandlerFunc {
+	policy, ok := s.policies.RoutePolicy(route.Method, route.Path)
+	if !ok {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		ctx, err := runtime.AnnotateIncomingContext(r.Context(), s.mux, r, route.Path)
+		if err == nil {
+			ctx, err = s.authenticate(ctx, route.Method+" "+route.Path)
+		}
+		if err == nil {
+			err = s.policies.Evaluate(ctx, policy)
+		}
+		if err != nil {
+			_, outboundMarshaler := runtime.MarshalerForRequest(s.mux, r)
+			s.errorHandler(r.Context(), s.mux, outboundMarshaler, w, r, err)
+			return
+		}
+
+		handler(w, r.WithContext(ctx), pathParams)
+	}
+}
@@ -0,0 +1,225 @@
+package micro
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestLoadPolicies(t *testing.T) {
+	var should = require.New(t)
+
+	dir, err := ioutil.TempDir("", "policies")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "policies.yaml")
+	should.NoError(ioutil.WriteFile(file, []byte(`
+methods:
+  /test.Service/Admin:
+    roles: [admin]
+  /test.Service/*:
+    scopes: [read]
+routes:
+  GET /private:
+    roles: [admin]
+`), 0644))
+
+	policies, err := LoadPolicies(file)
+	should.NoError(err)
+
+	policy, ok := policies.MethodPolicy("/test.Service/Admin")
+	should.True(ok)
+	should.Equal([]string{"admin"}, policy.Roles)
+
+	policy, ok = policies.MethodPolicy("/test.Service/Get")
+	should.True(ok)
+	should.Equal([]string{"read"}, policy.Scopes)
+
+	_, ok = policies.MethodPolicy("/other.Service/Get")
+	should.False(ok)
+
+	_, ok = policies.RoutePolicy("GET", "/private")
+	should.True(ok)
+
+	_, err = LoadPolicies(filepath.Join(dir, "not-exist.yaml"))
+	should.Error(err)
+}
+
+func TestPoliciesOverlappingPatterns(t *testing.T) {
+	var should = require.New(t)
+
+	policies := &Policies{Methods: map[string]Policy{
+		"/*/*":               {Roles: []string{"user"}},
+		"/test.Service/*":    {Roles: []string{"reader"}},
+		"/test.Service/Get*": {Roles: []string{"getter"}},
+	}}
+
+	// the most specific pattern is chosen every time
+	for i := 0; i < 20; i++ {
+		policy, ok := policies.MethodPolicy("/test.Service/GetUser")
+		should.True(ok)
+		should.Equal([]string{"getter"}, policy.Roles)
+
+		policy, ok = policies.MethodPolicy("/test.Service/List")
+		should.True(ok)
+		should.Equal([]string{"reader"}, policy.Roles)
+
+		policy, ok = policies.MethodPolicy("/other.Service/List")
+		should.True(ok)
+		should.Equal([]string{"user"}, policy.Roles)
+	}
+}
+
+func TestPoliciesEvaluate(t *testing.T) {
+	var should = require.New(t)
+
+	policies := &Policies{}
+	policy := Policy{Roles: []string{"admin", "editor"}, Scopes: []string{"read", "write"}}
+
+	err := policies.Evaluate(context.Background(), policy)
+	should.Equal(codes.PermissionDenied, status.Code(err))
+
+	ctx := NewClaimsContext(context.Background(), Claims{"roles": []interface{}{"editor"}, "scope": "read write"})
+	should.NoError(policies.Evaluate(ctx, policy))
+
+	ctx = NewClaimsContext(context.Background(), Claims{"roles": []interface{}{"viewer"}, "scope": "read write"})
+	should.Equal(codes.PermissionDenied, status.Code(policies.Evaluate(ctx, policy)))
+
+	ctx = NewClaimsContext(context.Background(), Claims{"roles": []interface{}{"admin"}, "scope": "read"})
+	should.Equal(codes.PermissionDenied, status.Code(policies.Evaluate(ctx, policy)))
+
+	// custom claim names
+	policies.RolesClaim = "groups"
+	ctx = NewClaimsContext(context.Background(), Claims{"groups": "admin", "scope": "read write"})
+	should.NoError(policies.Evaluate(ctx, policy))
+}
+
+func TestAuthorization(t *testing.T) {
+	var should = require.New(t)
+
+	authenticator := AuthenticatorFunc(func(ctx context.Context, fullMethod string) (context.Context, error) {
+		return NewClaimsContext(ctx, Claims{"roles": "viewer"}), nil
+	})
+	s := NewService(
+		Authentication(authenticator),
+		Authorization(&Policies{
+			Methods: map[string]Policy{"/test.Service/Admin": {Roles: []string{"admin"}}},
+			Routes:  map[string]Policy{"/private": {Roles: []string{"admin"}}},
+		}),
+	)
+	should.Len(s.unaryInterceptors, 5)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "OK", nil
+	}
+	_, err := s.authzUnaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Admin"}, handler)
+	should.Equal(codes.PermissionDenied, status.Code(err))
+
+	resp, err := s.authzUnaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler)
+	should.NoError(err)
+	should.Equal("OK", resp)
+
+	// route policies
+	routeHandler := func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		w.Write([]byte("OK"))
+	}
+	private := s.authorizeRoute(Route{Method: "GET", Path: "/private"}, routeHandler)
+	recorder := httptest.NewRecorder()
+	private(recorder, httptest.NewRequest("GET", "/private", nil), nil)
+	should.Equal(http.StatusForbidden, recorder.Code)
+
+	public := s.authorizeRoute(Route{Method: "GET", Path: "/public"}, routeHandler)
+	recorder = httptest.NewRecorder()
+	public(recorder, httptest.NewRequest("GET", "/public", nil), nil)
+	should.Equal(http.StatusOK, recorder.Code)
+}
+
+func TestPoliciesLoadProto(t *testing.T) {
+	var should = require.New(t)
+
+	// build a proto file with the policy extension on method options
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("micro/policy_test.proto"),
+		Package:    proto.String("micro.test"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		Syntax:     proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Policy"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{
+						Name:     proto.String("roles"),
+						JsonName: proto.String("roles"),
+						Number:   proto.Int32(1),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+					},
+				},
+			},
+			{Name: proto.String("Empty")},
+		},
+		Extension: []*descriptorpb.FieldDescriptorProto{
+			{
+				Name:     proto.String("policy"),
+				JsonName: proto.String("policy"),
+				Number:   proto.Int32(50000),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(".micro.test.Policy"),
+				Extendee: proto.String(".google.protobuf.MethodOptions"),
+			},
+		},
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	should.NoError(err)
+	ext := dynamicpb.NewExtensionType(fd.Extensions().Get(0))
+
+	policy := dynamicpb.NewMessage(fd.Messages().Get(0))
+	roles := policy.Mutable(fd.Messages().Get(0).Fields().Get(0)).List()
+	roles.Append(protoreflect.ValueOfString("admin"))
+
+	opts := &descriptorpb.MethodOptions{}
+	proto.SetExtension(opts, ext, policy)
+	file.Service = []*descriptorpb.ServiceDescriptorProto{
+		{
+			Name: proto.String("Admin"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       proto.String("Delete"),
+					InputType:  proto.String(".micro.test.Empty"),
+					OutputType: proto.String(".micro.test.Empty"),
+					Options:    opts,
+				},
+			},
+		},
+	}
+
+	fd, err = protodesc.NewFile(file, protoregistry.GlobalFiles)
+	should.NoError(err)
+	should.NoError(protoregistry.GlobalFiles.RegisterFile(fd))
+
+	policies := &Policies{}
+	should.NoError(policies.LoadProto(ext))
+
+	p, ok := policies.MethodPolicy("/micro.test.Admin/Delete")
+	should.True(ok)
+	should.Equal([]string{"admin"}, p.Roles)
+	should.Empty(p.Scopes)
+}
@@ -0,0 +1,127 @@
+package micro
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// the content type of RFC 7807 problem details
+const problemContentType = "application/problem+json"
+
+// problem is the problem details of RFC 7807, the gRPC code and the well known error details are the
+// extension members
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Code is the name of the gRPC code like NOT_FOUND
+	Code          string                `json:"code"`
+	InvalidParams []problemInvalidParam `json:"invalid-params,omitempty"`
+	Reason        string                `json:"reason,omitempty"`
+	Domain        string                `json:"domain,omitempty"`
+	Metadata      map[string]string     `json:"metadata,omitempty"`
+	// RetryAfter is the delay in seconds before retrying
+	RetryAfter int64 `json:"retry-after,omitempty"`
+	// Details are the other error details in protobuf JSON
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+// problemInvalidParam is the field violation of BadRequest
+type problemInvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// newProblem converts the status into the problem details, the status is set once the http status is known
+func newProblem(st *status.Status, r *http.Request) *problem {
+	p := &problem{
+		Type:     "about:blank",
+		Detail:   st.Message(),
+		Instance: r.URL.Path,
+		Code:     code.Code(st.Code()).String(),
+	}
+
+	for _, detail := range st.Proto().Details {
+		msg, err := detail.UnmarshalNew()
+		if err != nil {
+			continue
+		}
+
+		switch d := msg.(type) {
+		case *errdetails.BadRequest:
+			for _, v := range d.FieldViolations {
+				p.InvalidParams = append(p.InvalidParams, problemInvalidParam{Name: v.Field, Reason: v.Description})
+			}
+		case *errdetails.ErrorInfo:
+			p.Reason, p.Domain, p.Metadata = d.Reason, d.Domain, d.Metadata
+		case *errdetails.RetryInfo:
+			p.RetryAfter = int64(math.Ceil(d.RetryDelay.AsDuration().Seconds()))
+		default:
+			if data, err := protojson.Marshal(detail); err == nil {
+				p.Details = append(p.Details, data)
+			}
+		}
+	}
+
+	return p
+}
+
+// ProblemJSONErrorHandler renders the errors as RFC 7807 application/problem+json, the title is the text of the
+// http status mapped from the gRPC code and the detail is the message. The BadRequest, ErrorInfo and RetryInfo
+// details are mapped into the members invalid-params, reason, domain, metadata and retry-after, other details
+// are kept in details. The metadata and the trailers are forwarded as runtime.DefaultHTTPErrorHandler does
+func ProblemJSONErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	pw := &problemResponseWriter{ResponseWriter: w, problem: newProblem(status.Convert(err), r)}
+	runtime.DefaultHTTPErrorHandler(ctx, mux, marshaler, pw, r, err)
+}
+
+// problemResponseWriter replaces the body written by the default error handler with the problem details
+type problemResponseWriter struct {
+	http.ResponseWriter
+	problem   *problem
+	wroteBody bool
+}
+
+// WriteHeader implements http.ResponseWriter interface
+func (pw *problemResponseWriter) WriteHeader(statusCode int) {
+	pw.problem.Status = statusCode
+	pw.problem.Title = http.StatusText(statusCode)
+
+	header := pw.Header()
+	header.Set("Content-Type", problemContentType)
+	header.Del("Content-Length")
+	if pw.problem.RetryAfter > 0 && header.Get("Retry-After") == "" {
+		header.Set("Retry-After", strconv.FormatInt(pw.problem.RetryAfter, 10))
+	}
+
+	pw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements http.ResponseWriter interface, the body of the default error handler is dropped
+func (pw *problemResponseWriter) Write(b []byte) (int, error) {
+	if pw.wroteBody {
+		return len(b), nil
+	}
+	pw.wroteBody = true
+
+	data, err := json.Marshal(pw.problem)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := pw.ResponseWriter.Write(data); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
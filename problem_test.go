@@ -0,0 +1,114 @@
+package micro
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestProblemJSONErrorHandler(t *testing.T) {
+	var should = require.New(t)
+
+	mux := runtime.NewServeMux()
+	marshaler := &runtime.JSONPb{}
+	req := httptest.NewRequest("POST", "/v1/users?x=1", nil)
+
+	st, err := status.New(codes.InvalidArgument, "invalid user").WithDetails(
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "name", Description: "must not be empty"},
+			{Field: "age", Description: "must be positive"},
+		}},
+		&errdetails.ErrorInfo{Reason: "INVALID_USER", Domain: "example.com", Metadata: map[string]string{"id": "1"}},
+		&errdetails.Help{Links: []*errdetails.Help_Link{{Description: "docs", Url: "https://example.com"}}},
+	)
+	should.NoError(err)
+
+	w := httptest.NewRecorder()
+	ProblemJSONErrorHandler(context.Background(), mux, marshaler, w, req, st.Err())
+	should.Equal(http.StatusBadRequest, w.Code)
+	should.Equal("application/problem+json", w.Header().Get("Content-Type"))
+	should.JSONEq(`{
+		"type": "about:blank",
+		"title": "Bad Request",
+		"status": 400,
+		"detail": "invalid user",
+		"instance": "/v1/users",
+		"code": "INVALID_ARGUMENT",
+		"invalid-params": [
+			{"name": "name", "reason": "must not be empty"},
+			{"name": "age", "reason": "must be positive"}
+		],
+		"reason": "INVALID_USER",
+		"domain": "example.com",
+		"metadata": {"id": "1"},
+		"details": [
+			{"@type": "type.googleapis.com/google.rpc.Help", "links": [{"description": "docs", "url": "https://example.com"}]}
+		]
+	}`, w.Body.String())
+
+	// the retry delay is rounded up to seconds and set as Retry-After
+	st, err = status.New(codes.ResourceExhausted, "too many requests").WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(1500 * time.Millisecond)},
+	)
+	should.NoError(err)
+	w = httptest.NewRecorder()
+	ProblemJSONErrorHandler(context.Background(), mux, marshaler, w, req, st.Err())
+	should.Equal(http.StatusTooManyRequests, w.Code)
+	should.Equal("2", w.Header().Get("Retry-After"))
+	should.JSONEq(`{
+		"type": "about:blank",
+		"title": "Too Many Requests",
+		"status": 429,
+		"detail": "too many requests",
+		"instance": "/v1/users",
+		"code": "RESOURCE_EXHAUSTED",
+		"retry-after": 2
+	}`, w.Body.String())
+
+	// the metadata is forwarded as the default error handler does
+	ctx := runtime.NewServerMetadataContext(context.Background(), runtime.ServerMetadata{
+		HeaderMD: metadata.Pairs("foo", "bar"),
+	})
+	w = httptest.NewRecorder()
+	ProblemJSONErrorHandler(ctx, mux, marshaler, w, req, status.Error(codes.Internal, "boom"))
+	should.Equal(http.StatusInternalServerError, w.Code)
+	should.Equal("bar", w.Header().Get("Grpc-Metadata-Foo"))
+	should.JSONEq(`{
+		"type": "about:blank",
+		"title": "Internal Server Error",
+		"status": 500,
+		"detail": "boom",
+		"instance": "/v1/users",
+		"code": "INTERNAL"
+	}`, w.Body.String())
+}
+
+func TestProblemJSON(t *testing.T) {
+	var should = require.New(t)
+
+	s, err := New(ProblemJSON())
+	should.NoError(err)
+
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, httptest.NewRequest("GET", "/v1/unknown", nil))
+	should.Equal(http.StatusNotFound, w.Code)
+	should.Equal("application/problem+json", w.Header().Get("Content-Type"))
+	should.JSONEq(`{
+		"type": "about:blank",
+		"title": "Not Found",
+		"status": 404,
+		"detail": "Not Found",
+		"instance": "/v1/unknown",
+		"code": "NOT_FOUND"
+	}`, w.Body.String())
+}
@@ -0,0 +1,354 @@
+package micro
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// the idle time after which the token buckets are removed from the memory limiter
+const bucketIdleTimeout = 10 * time.Minute
+
+// Rate is the token bucket rate, the bucket is refilled with Limit tokens per second and holds
+// at most Burst tokens, the zero Rate means unlimited
+type Rate struct {
+	// Limit is the number of requests allowed per second
+	Limit float64 `yaml:"limit" json:"limit"`
+	// Burst is the maximum number of requests allowed at once, default is the ceiling of Limit
+	Burst int `yaml:"burst" json:"burst"`
+}
+
+// Limiter takes tokens from the token buckets, the in-memory implementation can be replaced by a
+// shared store so that the limits are enforced across the instances
+type Limiter interface {
+	// Allow takes a token from the bucket identified by the key, it returns false and the duration
+	// to wait before retry if the bucket is empty
+	Allow(ctx context.Context, key string, rate Rate) (bool, time.Duration, error)
+	// Refund puts back the token taken by Allow, it is called for the buckets which allowed the request
+	// once a later bucket rejects it
+	Refund(ctx context.Context, key string, rate Rate) error
+}
+
+// ClientKeyFunc returns the key identifying the client of the request
+type ClientKeyFunc func(ctx context.Context) string
+
+// RateLimitOpts is configures for rate limiting
+type RateLimitOpts struct {
+	// Global is the rate shared by all the requests
+	Global Rate `yaml:"global" json:"global"`
+	// Methods are the rates of gRPC methods, the key is the full method name, path.Match patterns
+	// like /package.Service/* are supported
+	Methods map[string]Rate `yaml:"methods" json:"methods"`
+	// Routes are the rates of the http routes added by RouteOpt or AddRoutes, the key is the route path
+	// like /docs, or the http method and path separated by a space like "GET /docs"
+	Routes map[string]Rate `yaml:"routes" json:"routes"`
+	// PerClient is the rate of each client identified by ClientKey
+	PerClient Rate `yaml:"per_client" json:"per_client"`
+	// ClientKey identifies the client, default is the subject of the claims set by the authenticators
+	// such as the JWT subject or the api key principal, or the client ip if not authenticated
+	ClientKey ClientKeyFunc `yaml:"-" json:"-"`
+	// Limiter is the token bucket store, default is an in-memory limiter
+	Limiter Limiter `yaml:"-" json:"-"`
+}
+
+// MemoryLimiter is the in-memory Limiter
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewMemoryLimiter creates a new in-memory limiter
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow implements Limiter interface
+func (m *MemoryLimiter) Allow(ctx context.Context, key string, rate Rate) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	burst := rate.burst()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		m.buckets[key] = b
+	}
+
+	// refill the bucket for the elapsed time
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate.Limit)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	wait := time.Duration((1 - b.tokens) / rate.Limit * float64(time.Second))
+
+	return false, wait, nil
+}
+
+// Refund implements Limiter interface
+func (m *MemoryLimiter) Refund(ctx context.Context, key string, rate Rate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if b, ok := m.buckets[key]; ok {
+		b.tokens = math.Min(rate.burst(), b.tokens+1)
+	}
+
+	return nil
+}
+
+// sweep removes the idle buckets which must have been refilled
+func (m *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < bucketIdleTimeout {
+		return
+	}
+
+	for key, b := range m.buckets {
+		if now.Sub(b.last) > bucketIdleTimeout {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
+
+func (r Rate) unlimited() bool {
+	return r.Limit <= 0
+}
+
+func (r Rate) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+
+	return math.Max(1, math.Ceil(r.Limit))
+}
+
+// rateLimiter enforces the rate limits, the opts can be replaced at runtime
+type rateLimiter struct {
+	mu      sync.RWMutex
+	opts    RateLimitOpts
+	methods *methodMatcher
+	service *Service
+}
+
+func newRateLimiter(s *Service, opts RateLimitOpts) *rateLimiter {
+	r := &rateLimiter{service: s}
+	r.update(opts)
+
+	return r
+}
+
+// update replaces the limits, the buckets in the limiter are kept
+func (r *rateLimiter) update(opts RateLimitOpts) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if opts.Limiter == nil {
+		opts.Limiter = r.opts.Limiter
+	}
+	if opts.Limiter == nil {
+		opts.Limiter = NewMemoryLimiter()
+	}
+	if opts.ClientKey == nil {
+		opts.ClientKey = r.service.defaultClientKey
+	}
+
+	r.opts = opts
+
+	keys := make([]string, 0, len(opts.Methods))
+	for key := range opts.Methods {
+		keys = append(keys, key)
+	}
+	r.methods = newMethodMatcher(keys)
+}
+
+// defaultClientKey is the subject of the claims or the client ip
+func (s *Service) defaultClientKey(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok && claims.Subject() != "" {
+		return "sub:" + claims.Subject()
+	}
+
+	return "ip:" + s.clientIP(ctx)
+}
+
+// rateLimitBucket is a bucket to take a token from
+type rateLimitBucket struct {
+	key  string
+	rate Rate
+}
+
+// allow takes a token from each of the buckets, it returns the ResourceExhausted error carrying
+// RetryInfo if any of them is empty, the tokens taken from the other buckets are refunded then
+func (r *rateLimiter) allow(ctx context.Context, buckets []rateLimitBucket) (time.Duration, error) {
+	r.mu.RLock()
+	limiter := r.opts.Limiter
+	r.mu.RUnlock()
+
+	var taken []rateLimitBucket
+	for _, b := range buckets {
+		if b.rate.unlimited() {
+			continue
+		}
+
+		ok, wait, err := limiter.Allow(ctx, b.key, b.rate)
+		if err != nil {
+			// fail open as the rate limiting should not take the service down
+			r.service.logger.Printf("Rate limiter error: %v", err)
+			continue
+		}
+
+		if !ok {
+			for _, t := range taken {
+				if err := limiter.Refund(ctx, t.key, t.rate); err != nil {
+					r.service.logger.Printf("Rate limiter error: %v", err)
+				}
+			}
+
+			st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+			if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)}); err == nil {
+				st = detailed
+			}
+			return wait, st.Err()
+		}
+		taken = append(taken, b)
+	}
+
+	return 0, nil
+}
+
+// methodBuckets returns the buckets of the gRPC method
+func (r *rateLimiter) methodBuckets(ctx context.Context, fullMethod string) []rateLimitBucket {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	buckets := []rateLimitBucket{{key: "global", rate: r.opts.Global}}
+
+	if key, ok := r.methods.match(fullMethod); ok {
+		buckets = append(buckets, rateLimitBucket{key: "method:" + key, rate: r.opts.Methods[key]})
+	}
+
+	if !r.opts.PerClient.unlimited() {
+		buckets = append(buckets, rateLimitBucket{key: "client:" + r.opts.ClientKey(ctx), rate: r.opts.PerClient})
+	}
+
+	return buckets
+}
+
+// routeBuckets returns the buckets of the http route
+func (r *rateLimiter) routeBuckets(ctx context.Context, route Route) []rateLimitBucket {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	buckets := []rateLimitBucket{{key: "global", rate: r.opts.Global}}
+
+	if rate, ok := r.opts.Routes[route.Method+" "+route.Path]; ok {
+		buckets = append(buckets, rateLimitBucket{key: "route:" + route.Method + " " + route.Path, rate: rate})
+	} else if rate, ok := r.opts.Routes[route.Path]; ok {
+		buckets = append(buckets, rateLimitBucket{key: "route:" + route.Path, rate: rate})
+	}
+
+	if !r.opts.PerClient.unlimited() {
+		buckets = append(buckets, rateLimitBucket{key: "client:" + r.opts.ClientKey(ctx), rate: r.opts.PerClient})
+	}
+
+	return buckets
+}
+
+func (r *rateLimiter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if wait, err := r.allow(ctx, r.methodBuckets(ctx, info.FullMethod)); err != nil {
+		grpc.SetHeader(ctx, retryAfterMetadata(wait))
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (r *rateLimiter) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := stream.Context()
+	if wait, err := r.allow(ctx, r.methodBuckets(ctx, info.FullMethod)); err != nil {
+		stream.SetHeader(retryAfterMetadata(wait))
+		return err
+	}
+
+	return handler(srv, stream)
+}
+
+// limitRoute wraps the route handler with the rate limits, it responds 429 with Retry-After header
+func (r *rateLimiter) limitRoute(route Route, handler runtime.HandlerFunc) runtime.HandlerFunc {
+	s := r.service
+
+	return func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+		ctx, err := runtime.AnnotateIncomingContext(req.Context(), s.mux, req, route.Path)
+		if err == nil {
+			var wait time.Duration
+			if wait, err = r.allow(ctx, r.routeBuckets(ctx, route)); err != nil {
+				w.Header().Set("Retry-After", retryAfterSeconds(wait))
+			}
+		}
+		if err != nil {
+			_, outboundMarshaler := runtime.MarshalerForRequest(s.mux, req)
+			s.errorHandler(req.Context(), s.mux, outboundMarshaler, w, req, err)
+			return
+		}
+
+		handler(w, req, pathParams)
+	}
+}
+
+// retryAfterHeaderMatcher wraps the outgoing header matcher set by WithOutgoingHeaderMatcher, the retry-after
+// header metadata which it does not match is mapped to the Retry-After http header. The others are prefixed
+// like the default outgoing header matcher of the gateway if no matcher is set
+func retryAfterHeaderMatcher(next runtime.HeaderMatcherFunc) runtime.HeaderMatcherFunc {
+	return func(key string) (string, bool) {
+		if next != nil {
+			if header, ok := next(key); ok {
+				return header, true
+			}
+		}
+		if key == "retry-after" {
+			return "Retry-After", true
+		}
+		if next != nil {
+			return "", false
+		}
+
+		return runtime.MetadataHeaderPrefix + key, true
+	}
+}
+
+func retryAfterMetadata(wait time.Duration) metadata.MD {
+	return metadata.Pairs("retry-after", retryAfterSeconds(wait))
+}
+
+// retryAfterSeconds returns the value of Retry-After header which is rounded up to seconds
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
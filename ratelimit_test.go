@@ -0,0 +1,211 @@
+package micro
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	var should = require.New(t)
+
+	now := time.Now()
+	limiter := NewMemoryLimiter()
+	limiter.now = func() time.Time { return now }
+	rate := Rate{Limit: 2, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		ok, _, err := limiter.Allow(context.Background(), "key", rate)
+		should.NoError(err)
+		should.True(ok)
+	}
+
+	ok, wait, err := limiter.Allow(context.Background(), "key", rate)
+	should.NoError(err)
+	should.False(ok)
+	should.Equal(500*time.Millisecond, wait)
+
+	// other keys have their own buckets
+	ok, _, _ = limiter.Allow(context.Background(), "other", rate)
+	should.True(ok)
+
+	// the bucket is refilled
+	now = now.Add(500 * time.Millisecond)
+	ok, _, _ = limiter.Allow(context.Background(), "key", rate)
+	should.True(ok)
+
+	// the refunded token can be taken again
+	ok, _, _ = limiter.Allow(context.Background(), "key", rate)
+	should.False(ok)
+	should.NoError(limiter.Refund(context.Background(), "key", rate))
+	ok, _, _ = limiter.Allow(context.Background(), "key", rate)
+	should.True(ok)
+
+	// idle buckets are removed
+	now = now.Add(2 * bucketIdleTimeout)
+	limiter.Allow(context.Background(), "key", rate)
+	should.Len(limiter.buckets, 1)
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(RateLimit(RateLimitOpts{
+		Methods: map[string]Rate{
+			"/test.Service/Slow": {Limit: 1},
+			"/test.Service/*":    {Limit: 100},
+		},
+		PerClient: Rate{Limit: 1, Burst: 2},
+		ClientKey: func(ctx context.Context) string {
+			return ctx.Value(clientCtxKey{}).(string)
+		},
+	}))
+	should.Len(s.unaryInterceptors, 4)
+	should.Len(s.annotators, 1)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "OK", nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Slow"}
+	alice := context.WithValue(context.Background(), clientCtxKey{}, "alice")
+	bob := context.WithValue(context.Background(), clientCtxKey{}, "bob")
+
+	_, err := s.rateLimiter.unaryInterceptor(alice, nil, info, handler)
+	should.NoError(err)
+
+	// the method limit is shared by the clients
+	_, err = s.rateLimiter.unaryInterceptor(bob, nil, info, handler)
+	should.Equal(codes.ResourceExhausted, status.Code(err))
+	details := status.Convert(err).Details()
+	should.Len(details, 1)
+	should.IsType(&errdetails.RetryInfo{}, details[0])
+
+	// the client limit is shared by the methods
+	info.FullMethod = "/test.Service/Fast"
+	_, err = s.rateLimiter.unaryInterceptor(alice, nil, info, handler)
+	should.NoError(err)
+	_, err = s.rateLimiter.unaryInterceptor(alice, nil, info, handler)
+	should.Equal(codes.ResourceExhausted, status.Code(err))
+	_, err = s.rateLimiter.unaryInterceptor(bob, nil, info, handler)
+	should.NoError(err)
+}
+
+type clientCtxKey struct{}
+
+func TestRateLimitRefund(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(RateLimit(RateLimitOpts{
+		Methods:   map[string]Rate{"/test.Service/Slow": {Limit: 1}},
+		PerClient: Rate{Limit: 1},
+		ClientKey: func(ctx context.Context) string {
+			return ctx.Value(clientCtxKey{}).(string)
+		},
+	}))
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "OK", nil
+	}
+	alice := context.WithValue(context.Background(), clientCtxKey{}, "alice")
+	bob := context.WithValue(context.Background(), clientCtxKey{}, "bob")
+
+	_, err := s.rateLimiter.unaryInterceptor(alice, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Fast"}, handler)
+	should.NoError(err)
+
+	// the method token taken before the client limit rejects alice is refunded for bob
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Slow"}
+	_, err = s.rateLimiter.unaryInterceptor(alice, nil, info, handler)
+	should.Equal(codes.ResourceExhausted, status.Code(err))
+	_, err = s.rateLimiter.unaryInterceptor(bob, nil, info, handler)
+	should.NoError(err)
+}
+
+func TestRateLimitRoute(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(RateLimit(RateLimitOpts{
+		Routes: map[string]Rate{"GET /limited": {Limit: 1}},
+	}))
+
+	route := Route{
+		Method: "GET",
+		Path:   "/limited",
+		Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			w.Write([]byte("OK"))
+		},
+	}
+	handler := s.rateLimiter.limitRoute(route, route.Handler)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/limited", nil), nil)
+	should.Equal(http.StatusOK, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/limited", nil), nil)
+	should.Equal(http.StatusTooManyRequests, recorder.Code)
+	should.Equal("1", recorder.Header().Get("Retry-After"))
+}
+
+func TestRetryAfterHeaderMatcher(t *testing.T) {
+	var should = require.New(t)
+
+	matcher := retryAfterHeaderMatcher(nil)
+	key, ok := matcher("retry-after")
+	should.True(ok)
+	should.Equal("Retry-After", key)
+
+	key, ok = matcher("foo")
+	should.True(ok)
+	should.Equal("Grpc-Metadata-foo", key)
+
+	// the matcher of the user is called first
+	matcher = retryAfterHeaderMatcher(func(key string) (string, bool) {
+		return "X-" + key, key == "trace-id"
+	})
+	key, ok = matcher("trace-id")
+	should.True(ok)
+	should.Equal("X-trace-id", key)
+	key, ok = matcher("retry-after")
+	should.True(ok)
+	should.Equal("Retry-After", key)
+	_, ok = matcher("foo")
+	should.False(ok)
+
+	// the rate limit keeps the matcher of the user whatever the order of the options
+	s := NewService(RateLimit(RateLimitOpts{}), WithOutgoingHeaderMatcher(func(key string) (string, bool) {
+		return "X-" + key, key == "trace-id"
+	}))
+	recorder := httptest.NewRecorder()
+	ctx := runtime.NewServerMetadataContext(context.Background(), runtime.ServerMetadata{
+		HeaderMD: metadata.Pairs("trace-id", "1", "retry-after", "2"),
+	})
+	runtime.ForwardResponseMessage(ctx, s.mux, &runtime.JSONPb{}, recorder, httptest.NewRequest("GET", "/", nil), &emptypb.Empty{})
+	should.Equal("1", recorder.Header().Get("X-trace-id"))
+	should.Equal("2", recorder.Header().Get("Retry-After"))
+
+	_, err := New(WithOutgoingHeaderMatcher(nil))
+	should.EqualError(err, "outgoing header matcher must not be nil")
+}
+
+func TestDefaultClientKey(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService()
+	ctx := NewClaimsContext(context.Background(), Claims{"sub": "alice"})
+	should.Equal("sub:alice", s.defaultClientKey(ctx))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	ctx = metadata.NewIncomingContext(context.Background(), s.clientIPAnnotator(context.Background(), req))
+	should.Equal("ip:192.0.2.1", s.defaultClientKey(ctx))
+}
@@ -2,23 +2,25 @@ package micro
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
 	"net/http"
+	"strings"
 )
 
 // RedocOpts is configures for redoc
 type RedocOpts struct {
 	// Route is the route in http server, should include / at the beginning, default is /docs.
 	// Currently it can not be root route "/", see https://github.com/grpc-ecosystem/grpc-gateway/issues/1909
-	Route string
+	Route string `yaml:"route" json:"route"`
 	// SpecURLs are the urls to find the spec for, format: name -> url
-	SpecURLs map[string]string
+	SpecURLs map[string]string `yaml:"spec_urls" json:"spec_urls"`
 	// RedocURL is the js that generates the redoc site, defaults to: https://cdn.jsdelivr.net/npm/redoc@next/bundles/redoc.standalone.js
-	RedocURL string
+	RedocURL string `yaml:"redoc_url" json:"redoc_url"`
 	// Title is the page title, default to: API documentation
-	Title string
+	Title string `yaml:"title" json:"title"`
 	// Up is whether to boot up the redoc endpoints
-	Up bool
+	Up bool `yaml:"up" json:"up"`
 }
 
 // EnsureDefaults sets default redoc options
@@ -40,6 +42,21 @@ func (redoc *RedocOpts) EnsureDefaults() {
 	}
 }
 
+// Validate validates the redoc options
+func (redoc *RedocOpts) Validate() error {
+	if redoc.Route != "" && !strings.HasPrefix(redoc.Route, "/") {
+		return fmt.Errorf("route should start with /: %s", redoc.Route)
+	}
+
+	for name, url := range redoc.SpecURLs {
+		if name == "" || url == "" {
+			return fmt.Errorf("spec name and url must not be empty: %q -> %q", name, url)
+		}
+	}
+
+	return nil
+}
+
 // AddSpec adds a spec url with name
 func (redoc *RedocOpts) AddSpec(name, url string) *RedocOpts {
 	if redoc.SpecURLs == nil {
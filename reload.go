@@ -0,0 +1,134 @@
+package micro
+
+import (
+	"crypto/tls"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// currentRedoc returns the redoc options which can be replaced by reloading
+func (s *Service) currentRedoc() *RedocOpts {
+	s.redocMu.RLock()
+	defer s.redocMu.RUnlock()
+
+	return s.redoc
+}
+
+// Reload re-reads the config from the file and environment which the service is created with, see
+// Config.Options. The log level, rate limits, CORS origins, TLS certificates and redoc spec urls are
+// applied live, the changes of the other parts are not applied but logged as warnings as they require
+// restarting the service. Nothing is applied if the new config is invalid
+func (s *Service) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if s.config == nil {
+		return errors.New("the service is not created from a config")
+	}
+
+	config, err := LoadConfig(s.config.file, s.config.envPrefix)
+	if err != nil {
+		return err
+	}
+
+	// load the certificate first so that nothing is applied if it is invalid
+	var certificate *tls.Certificate
+	if s.certificate != nil && config.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLS.CertFile, config.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		certificate = &cert
+	}
+
+	for _, name := range notReloadableChanges(s.config, config) {
+		s.log.logf(logLevelWarn, "Config %s can not be reloaded, restart the service to apply it", name)
+	}
+
+	s.log.setLevel(config.LogLevel)
+	s.log.logf(logLevelDebug, "Reloaded log level: %s", config.LogLevel)
+
+	if s.cors != nil {
+		s.cors.update(config.CORSOrigins)
+		s.log.logf(logLevelDebug, "Reloaded CORS origins: %v", config.CORSOrigins)
+	}
+
+	switch {
+	case s.rateLimiter != nil && config.RateLimit != nil:
+		s.rateLimiter.update(*config.RateLimit)
+		s.log.logf(logLevelDebug, "Reloaded rate limits")
+	case s.rateLimiter != nil:
+		// disable the limits but keep the interceptors
+		s.rateLimiter.update(RateLimitOpts{})
+		s.log.logf(logLevelDebug, "Disabled rate limits")
+	case config.RateLimit != nil:
+		s.log.logf(logLevelWarn, "Config rate_limit can not be enabled by reloading, restart the service to apply it")
+	}
+
+	if certificate != nil {
+		s.certificate.Store(certificate)
+		s.log.logf(logLevelDebug, "Reloaded TLS certificate: %s", config.TLS.CertFile)
+	} else if (s.certificate != nil) != (config.TLS.CertFile != "") {
+		s.log.logf(logLevelWarn, "Config tls can not be enabled or disabled by reloading, restart the service to apply it")
+	}
+
+	s.reloadRedoc(config.Redoc.SpecURLs)
+
+	// s.config is kept so that the not reloadable changes are compared with the running config
+	s.logger.Printf("Config reloaded")
+
+	return nil
+}
+
+// reloadRedoc replaces the redoc spec urls, the local spec files which are not served yet require restarting
+func (s *Service) reloadRedoc(specURLs map[string]string) {
+	s.redocMu.Lock()
+	defer s.redocMu.Unlock()
+
+	redoc := *s.redoc
+	redoc.SpecURLs = specURLs
+	if redoc.Up {
+		redoc.EnsureDefaults()
+	}
+
+	for _, url := range redoc.SpecURLs {
+		if strings.HasPrefix(url, "/") && !s.HasRoute(Route{Method: "GET", Path: url}) {
+			s.log.logf(logLevelWarn, "Redoc spec %s is not served until the service is restarted", url)
+		}
+	}
+
+	s.redoc = &redoc
+	s.log.logf(logLevelDebug, "Reloaded redoc specs: %v", redoc.SpecURLs)
+}
+
+// notReloadableChanges returns the names of the changed fields which can not be reloaded
+func notReloadableChanges(current, next *Config) []string {
+	// clear the reloadable fields so that only the other fields are compared
+	withoutReloadable := func(c Config) Config {
+		c.LogLevel = ""
+		c.CORSOrigins = nil
+		c.RateLimit = nil
+		c.Redoc.SpecURLs = nil
+		c.TLS.CertFile = ""
+		c.TLS.KeyFile = ""
+		return c
+	}
+	currentValue := reflect.ValueOf(withoutReloadable(*current))
+	nextValue := reflect.ValueOf(withoutReloadable(*next))
+
+	var names []string
+	t := currentValue.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		if !reflect.DeepEqual(currentValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			names = append(names, tag)
+		}
+	}
+
+	return names
+}
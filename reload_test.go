@@ -0,0 +1,128 @@
+package micro
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryLogger records the logged messages
+type memoryLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *memoryLogger) Printf(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.messages = append(l.messages, fmt.Sprintf(msg, args...))
+}
+
+func TestReload(t *testing.T) {
+	var should = require.New(t)
+
+	dir, err := ioutil.TempDir("", "micro")
+	should.NoError(err)
+	defer os.RemoveAll(dir)
+
+	certs, err := NewDevCertificates()
+	should.NoError(err)
+	certFile, keyFile := writeDevCertificate(t, dir, "server", certs.Server)
+
+	file := filepath.Join(dir, "config.yaml")
+	should.NoError(ioutil.WriteFile(file, []byte(fmt.Sprintf(`
+log_level: warn
+rate_limit:
+  global:
+    limit: 10
+redoc:
+  up: true
+tls:
+  cert_file: %s
+  key_file: %s
+`, certFile, keyFile)), 0600))
+
+	config, err := LoadConfig(file, "")
+	should.NoError(err)
+	opts, err := config.Options()
+	should.NoError(err)
+
+	logger := &memoryLogger{}
+	s := NewService(append(opts, WithLogger(logger))...)
+	should.False(s.cors.allowed("https://example.org"))
+	should.Equal(Rate{Limit: 10}, s.rateLimiter.opts.Global)
+
+	// the info logs are dropped at warn level
+	s.logger.Printf("dropped")
+	should.Empty(logger.messages)
+
+	// the reloadable parts are applied and the others are warned
+	renewed, err := NewDevCertificates()
+	should.NoError(err)
+	certFile, keyFile = writeDevCertificate(t, dir, "renewed", renewed.Server)
+	should.NoError(ioutil.WriteFile(file, []byte(fmt.Sprintf(`
+http_port: 8080
+log_level: debug
+cors_origins: [https://example.org]
+rate_limit:
+  global:
+    limit: 20
+redoc:
+  up: true
+  spec_urls:
+    Demo: https://example.org/demo.swagger.json
+tls:
+  cert_file: %s
+  key_file: %s
+`, certFile, keyFile)), 0600))
+
+	should.NoError(s.Reload())
+	should.Contains(logger.messages, "Config http_port can not be reloaded, restart the service to apply it")
+	should.Contains(logger.messages, "Config reloaded")
+	should.True(s.cors.allowed("https://example.org"))
+	should.Equal(Rate{Limit: 20}, s.rateLimiter.opts.Global)
+	should.Equal(map[string]string{"Demo": "https://example.org/demo.swagger.json"}, s.currentRedoc().SpecURLs)
+	should.Equal(renewed.Server.Certificate, s.certificate.Load().(*tls.Certificate).Certificate)
+	should.Contains(logger.messages, "Reloaded log level: debug")
+
+	// the not reloadable changes are warned again as they are not applied
+	logger.messages = nil
+	should.NoError(s.Reload())
+	should.Contains(logger.messages, "Config http_port can not be reloaded, restart the service to apply it")
+
+	// nothing is applied if the config is invalid
+	should.NoError(ioutil.WriteFile(file, []byte("log_level: verbose\ncors_origins: ['*']\n"), 0600))
+	should.Error(s.Reload())
+	should.False(s.cors.allowed("https://other.org"))
+
+	// the service not created from a config
+	should.Error(NewService().Reload())
+}
+
+func TestReloadRedocWhileStarting(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(Redoc(&RedocOpts{Up: true}))
+
+	// the routes are added by the start while the specs are reloaded
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.AddRoutes(Route{Method: "GET", Path: fmt.Sprintf("/spec%d.json", i), Handler: s.ServeFile})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		s.reloadRedoc(map[string]string{"spec": "/spec0.json"})
+	}
+	<-done
+
+	should.True(s.HasRoute(Route{Method: "GET", Path: "/spec99.json"}))
+}
@@ -0,0 +1,265 @@
+package micro
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// the environment variable passing the listeners to the new process keyed by listenerKey,
+	// e.g. grpc-9999:3,http-8888:4
+	listenFDsEnv = "MICRO_LISTEN_FDS"
+	// the environment variable passing the pipe which the new process writes to once it is ready
+	readyFDEnv = "MICRO_READY_FD"
+	// the default timeout waiting for the new process to be ready
+	defaultRestartTimeout = 30 * time.Second
+)
+
+// the services started in the process, the listeners of all of them are passed to the new process in
+// graceful restart
+var (
+	servicesMu sync.Mutex
+	services   = make(map[*Service]struct{})
+)
+
+// the restart in progress or succeeded in the process, the services receiving the same restart signal join
+// it so that only one new process is started with the listeners of all of them
+var (
+	restartMu      sync.Mutex
+	currentRestart *restartCall
+)
+
+// restartCall is the restart shared by the services in the process
+type restartCall struct {
+	done    chan struct{}
+	process *os.Process
+	err     error
+}
+
+// registerService adds the started service
+func registerService(s *Service) {
+	servicesMu.Lock()
+	defer servicesMu.Unlock()
+
+	services[s] = struct{}{}
+}
+
+// unregisterService removes the stopped service
+func unregisterService(s *Service) {
+	servicesMu.Lock()
+	defer servicesMu.Unlock()
+
+	delete(services, s)
+}
+
+// listenerFiles returns the files of the listeners of the service keyed by listenerKey
+func (s *Service) listenerFiles() (map[string]*os.File, error) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	files := make(map[string]*os.File)
+	for key, lis := range s.listeners {
+		fl, ok := lis.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			closeFiles(files)
+			return nil, fmt.Errorf("%s listener can not be passed to the new process", key)
+		}
+
+		file, err := fl.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files[key] = file
+	}
+
+	return files, nil
+}
+
+// closeFiles closes the files
+func closeFiles(files map[string]*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+// defaultRestartCommand runs the same executable with the same arguments
+func defaultRestartCommand() (*exec.Cmd, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd, nil
+}
+
+// restartProcess restarts the process once, the services restarting meanwhile wait for it and get its
+// result. A failed restart can be retried while the succeeded one is kept as the process is handed over
+func (s *Service) restartProcess() (*os.Process, error) {
+	restartMu.Lock()
+	call := currentRestart
+	if call != nil {
+		restartMu.Unlock()
+		<-call.done
+		return call.process, call.err
+	}
+	call = &restartCall{done: make(chan struct{})}
+	currentRestart = call
+	restartMu.Unlock()
+
+	call.process, call.err = s.restart()
+	if call.err != nil {
+		restartMu.Lock()
+		currentRestart = nil
+		restartMu.Unlock()
+	}
+	close(call.done)
+
+	return call.process, call.err
+}
+
+// restart starts the new process with the listeners of all the services in the process and waits until it
+// is ready, the new process is killed if it is not ready within the restart timeout
+func (s *Service) restart() (*os.Process, error) {
+	var names []string
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	servicesMu.Lock()
+	running := []*Service{s}
+	for service := range services {
+		if service != s {
+			running = append(running, service)
+		}
+	}
+	servicesMu.Unlock()
+
+	for _, service := range running {
+		serviceFiles, err := service.listenerFiles()
+		if err != nil {
+			return nil, err
+		}
+		for key, file := range serviceFiles {
+			names = append(names, fmt.Sprintf("%s:%d", key, 3+len(files)))
+			files = append(files, file)
+		}
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	cmd, err := s.restartCommand()
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	// the extra files are numbered from 3 in the new process
+	cmd.ExtraFiles = append(files, w)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(withoutEnv(cmd.Env, listenFDsEnv, readyFDEnv),
+		listenFDsEnv+"="+strings.Join(names, ","),
+		readyFDEnv+"="+strconv.Itoa(3+len(files)),
+	)
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Printf("Started new process %d, waiting for it to be ready", cmd.Process.Pid)
+
+	// the read fails with EOF if the new process exits before it is ready
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(s.restartTimeout):
+		err = errors.New("timeout")
+	}
+
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return nil, fmt.Errorf("new process %d is not ready: %v", cmd.Process.Pid, err)
+	}
+
+	return cmd.Process, nil
+}
+
+// notifyParent tells the parent process in graceful restart that the new process is ready, it is
+// notified once all the inherited listeners are taken by the services which are ready
+func (s *Service) notifyParent() {
+	s.listenersMu.Lock()
+	taken := s.inheritedListeners
+	s.inheritedListeners = 0
+	s.listenersMu.Unlock()
+
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+
+	inheritPending -= taken
+	if len(inherited) > 0 || inheritPending > 0 {
+		return
+	}
+
+	value := os.Getenv(readyFDEnv)
+	if value == "" {
+		return
+	}
+	os.Unsetenv(readyFDEnv)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return
+	}
+
+	pipe := os.NewFile(uintptr(fd), "ready")
+	pipe.Write([]byte{1})
+	pipe.Close()
+}
+
+// withoutEnv removes the variables from the environment
+func withoutEnv(env []string, names ...string) []string {
+	var result []string
+	for _, v := range env {
+		keep := true
+		for _, name := range names {
+			if strings.HasPrefix(v, name+"=") {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
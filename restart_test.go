@@ -0,0 +1,190 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// the route responds the pid of the process serving the request
+var pidRoute = Route{
+	Method: "GET",
+	Path:   "/pid",
+	Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		w.Write([]byte(strconv.Itoa(os.Getpid())))
+	},
+}
+
+func noopReverseProxyFunc(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error {
+	return nil
+}
+
+// TestRestartHelperProcess is the new process started by TestGracefulRestart
+func TestRestartHelperProcess(t *testing.T) {
+	if os.Getenv("MICRO_TEST_RESTART_HELPER") != "1" {
+		return
+	}
+
+	// the listeners are inherited on the same ports
+	s := NewService(RouteOpt(pidRoute), PreShutdownDelay(0))
+	s.Start(27888, 27999, noopReverseProxyFunc)
+	os.Exit(0)
+}
+
+func TestListenersFromEnv(t *testing.T) {
+	var should = require.New(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	defer lis.Close()
+
+	file, err := lis.(*net.TCPListener).File()
+	should.NoError(err)
+
+	os.Setenv(listenFDsEnv, fmt.Sprintf("%s:%d", listenerKey(grpcListenerName, 9999), file.Fd()))
+	listeners, err := listenersFromEnv()
+	should.NoError(err)
+	should.Equal(lis.Addr().String(), listeners["grpc-9999"].Addr().String())
+	should.Empty(os.Getenv(listenFDsEnv))
+	listeners["grpc-9999"].Close()
+
+	os.Setenv(listenFDsEnv, "grpc")
+	_, err = listenersFromEnv()
+	should.EqualError(err, "invalid MICRO_LISTEN_FDS: grpc")
+
+	should.Equal([]string{"A=1"}, withoutEnv([]string{"A=1", listenFDsEnv + "=grpc:3", readyFDEnv + "=5"}, listenFDsEnv, readyFDEnv))
+}
+
+func TestNotifyParent(t *testing.T) {
+	var should = require.New(t)
+
+	r, w, err := os.Pipe()
+	should.NoError(err)
+	defer r.Close()
+	defer w.Close()
+	fd, err := syscall.Dup(int(w.Fd()))
+	should.NoError(err)
+	os.Setenv(readyFDEnv, strconv.Itoa(fd))
+
+	// the parent is notified once both services which took the inherited listeners are ready
+	s1, s2 := NewService(), NewService()
+	inheritOnce.Do(func() {})
+	inheritMu.Lock()
+	inheritPending += 2
+	inheritMu.Unlock()
+	s1.inheritedListeners = 1
+	s2.inheritedListeners = 1
+
+	s1.notifyParent()
+	should.Equal(strconv.Itoa(fd), os.Getenv(readyFDEnv))
+
+	s2.notifyParent()
+	should.Empty(os.Getenv(readyFDEnv))
+	n, err := r.Read(make([]byte, 1))
+	should.NoError(err)
+	should.Equal(1, n)
+}
+
+func TestGracefulRestart(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(
+		RouteOpt(pidRoute),
+		PreShutdownDelay(0),
+		GracefulRestart(syscall.SIGUSR2, 10*time.Second),
+	)
+	s.restartCommand = func() (*exec.Cmd, error) {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestRestartHelperProcess$")
+		cmd.Env = append(os.Environ(), "MICRO_TEST_RESTART_HELPER=1")
+		return cmd, nil
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Start(27888, 27999, noopReverseProxyFunc)
+	}()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	getPid := func() int {
+		resp, err := http.Get("http://127.0.0.1:27888/pid")
+		should.NoError(err)
+		defer resp.Body.Close()
+
+		b, err := ioutil.ReadAll(resp.Body)
+		should.NoError(err)
+		pid, err := strconv.Atoi(string(b))
+		should.NoError(err)
+
+		return pid
+	}
+	should.Equal(os.Getpid(), getPid())
+
+	// the service stops once the new process is ready
+	should.NoError(syscall.Kill(os.Getpid(), syscall.SIGUSR2))
+	should.NoError(<-stopped)
+
+	// the new process serves on the same port
+	pid := getPid()
+	should.NotEqual(os.Getpid(), pid)
+	should.NoError(syscall.Kill(pid, syscall.SIGINT))
+
+	process, err := os.FindProcess(pid)
+	should.NoError(err)
+	_, err = process.Wait()
+	should.NoError(err)
+}
+
+func TestGracefulRestartServices(t *testing.T) {
+	var should = require.New(t)
+
+	// forget the restart of the other tests which is kept once succeeded
+	restartMu.Lock()
+	currentRestart = nil
+	restartMu.Unlock()
+
+	// the new process exits without being ready
+	var commands int32
+	restartCommand := func() (*exec.Cmd, error) {
+		atomic.AddInt32(&commands, 1)
+		return exec.Command("sleep", "1"), nil
+	}
+
+	a := NewService(GracefulRestart(syscall.SIGUSR2, 10*time.Second))
+	a.restartCommand = restartCommand
+	b := NewService(GracefulRestart(syscall.SIGUSR2, 10*time.Second))
+	b.restartCommand = restartCommand
+
+	// the services receiving the same signal share one restart
+	ha := a.newSignalHandler()
+	defer ha.force()
+	hb := b.newSignalHandler()
+	defer hb.force()
+	ha.handle(syscall.SIGUSR2)
+	hb.handle(syscall.SIGUSR2)
+
+	resultA, resultB := <-ha.restarted, <-hb.restarted
+	should.Error(resultA.err)
+	should.Equal(resultA.err, resultB.err)
+	should.Equal(int32(1), atomic.LoadInt32(&commands))
+
+	// the failed restart can be retried
+	ha.finishRestart(resultA)
+	ha.handle(syscall.SIGUSR2)
+	should.Error((<-ha.restarted).err)
+	should.Equal(int32(2), atomic.LoadInt32(&commands))
+}
@@ -1,6 +1,10 @@
 package micro
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 )
 
@@ -10,3 +14,20 @@ type Route struct {
 	Path    string
 	Handler runtime.HandlerFunc
 }
+
+// validate checks if the route can be handled by the mux
+func (route Route) validate() error {
+	if route.Method == "" {
+		return errors.New("route method must not be empty")
+	}
+
+	if !strings.HasPrefix(route.Path, "/") {
+		return fmt.Errorf("route path should start with /: %s", route.Path)
+	}
+
+	if route.Handler == nil {
+		return fmt.Errorf("route handler of %s %s must not be nil", route.Method, route.Path)
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job is the task run by the scheduler, the ctx is cancelled on shutdown or when the run times out
+type Job func(ctx context.Context) error
+
+// JobOpts are the options of the scheduled job, either Interval or Cron must be set
+type JobOpts struct {
+	// Name is the unique name of the job in the logs and metrics
+	Name string
+	// Interval runs the job at the fixed interval from the start
+	Interval time.Duration
+	// Cron runs the job by the standard 5-field cron expression in local time, e.g. "*/5 * * * *" or "@daily"
+	Cron string
+	// Jitter delays each run by a random duration up to it, so that the instances do not run at the same time
+	Jitter time.Duration
+	// Timeout cancels the ctx of each run after it, 0 means no timeout
+	Timeout time.Duration
+}
+
+// Validate checks if the options are valid
+func (o JobOpts) Validate() error {
+	if o.Name == "" {
+		return errors.New("job name must not be empty")
+	}
+	if (o.Interval > 0) == (o.Cron != "") {
+		return fmt.Errorf("job %s: either interval or cron must be set", o.Name)
+	}
+	if o.Interval < 0 {
+		return fmt.Errorf("job %s: interval must not be negative", o.Name)
+	}
+	if o.Cron != "" {
+		if _, err := parseCron(o.Cron); err != nil {
+			return fmt.Errorf("job %s: %v", o.Name, err)
+		}
+	}
+	if o.Jitter < 0 {
+		return fmt.Errorf("job %s: jitter must not be negative", o.Name)
+	}
+	if o.Timeout < 0 {
+		return fmt.Errorf("job %s: timeout must not be negative", o.Name)
+	}
+
+	return nil
+}
+
+// scheduledJob is the job with its schedule, running is set while a run is in progress
+type scheduledJob struct {
+	opts    JobOpts
+	job     Job
+	next    func(time.Time) time.Time
+	running int32
+}
+
+// Scheduler runs the jobs by their schedules until the ctx of Run is done, a run is skipped if the previous
+// run of the same job is still in progress and the panics of the jobs are recovered. The scheduler is
+// Runnable so that it can run in a Group, it runs with the service if the jobs are added by ScheduleJob
+type Scheduler struct {
+	// Logger logs the failures and the skipped runs of the jobs, it writes nothing by default
+	Logger Logger
+
+	mu      sync.Mutex
+	jobs    []*scheduledJob
+	running bool
+}
+
+// NewScheduler creates an empty scheduler
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		Logger: dummyLogger,
+	}
+}
+
+// Add adds the job, the jobs must be added before Run
+func (sc *Scheduler) Add(opts JobOpts, job Job) error {
+	if job == nil {
+		return fmt.Errorf("job %s must not be nil", opts.Name)
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.running {
+		return fmt.Errorf("job %s can not be added to the running scheduler", opts.Name)
+	}
+	for _, j := range sc.jobs {
+		if j.opts.Name == opts.Name {
+			return fmt.Errorf("job %s already exists", opts.Name)
+		}
+	}
+
+	j := &scheduledJob{opts: opts, job: job}
+	if opts.Interval > 0 {
+		j.next = func(t time.Time) time.Time { return t.Add(opts.Interval) }
+	} else {
+		cron, _ := parseCron(opts.Cron)
+		j.next = cron.next
+	}
+	sc.jobs = append(sc.jobs, j)
+
+	return nil
+}
+
+// Run runs the jobs until the ctx is done, then waits for the runs in progress to return
+func (sc *Scheduler) Run(ctx context.Context) error {
+	sc.mu.Lock()
+	if sc.running {
+		sc.mu.Unlock()
+		return errors.New("scheduler is already running")
+	}
+	sc.running = true
+	jobs := sc.jobs
+	sc.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j *scheduledJob) {
+			defer wg.Done()
+			sc.loop(ctx, j)
+		}(j)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// loop starts the runs of the job by its schedule
+func (sc *Scheduler) loop(ctx context.Context, j *scheduledJob) {
+	var runs sync.WaitGroup
+	defer runs.Wait()
+
+	scheduled := time.Now()
+	for {
+		// the missed runs are skipped if the runs fall behind, e.g. the process was suspended
+		scheduled = j.next(scheduled)
+		if now := time.Now(); !scheduled.IsZero() && scheduled.Before(now) {
+			scheduled = j.next(now)
+		}
+		if scheduled.IsZero() {
+			sc.Logger.Printf("Job %s will never run again", j.opts.Name)
+			return
+		}
+
+		delay := time.Until(scheduled)
+		if j.opts.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(j.opts.Jitter) + 1))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !atomic.CompareAndSwapInt32(&j.running, 0, 1) {
+			jobSkippedCounter.WithLabelValues(j.opts.Name).Inc()
+			sc.Logger.Printf("Job %s is skipped since the previous run is still in progress", j.opts.Name)
+			continue
+		}
+
+		runs.Add(1)
+		go func() {
+			defer runs.Done()
+			defer atomic.StoreInt32(&j.running, 0)
+			sc.run(ctx, j)
+		}()
+	}
+}
+
+// run runs the job once and records the metrics
+func (sc *Scheduler) run(ctx context.Context, j *scheduledJob) {
+	if j.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.opts.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := sc.call(ctx, j)
+	jobDurationHistogram.WithLabelValues(j.opts.Name).Observe(time.Since(start).Seconds())
+	jobRunsCounter.WithLabelValues(j.opts.Name).Inc()
+
+	if err != nil {
+		jobFailuresCounter.WithLabelValues(j.opts.Name).Inc()
+		sc.Logger.Printf("Job %s failed: %v", j.opts.Name, err)
+	}
+}
+
+// call calls the job with the panic recovered as an error
+func (sc *Scheduler) call(ctx context.Context, j *scheduledJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			sc.Logger.Printf("Job %s panicked: %v\n%s", j.opts.Name, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return j.job(ctx)
+}
+
+// startScheduler runs the scheduler of the service in the background until stopScheduler
+func (s *Service) startScheduler() {
+	s.schedulerMu.Lock()
+	defer s.schedulerMu.Unlock()
+
+	if s.scheduler == nil || s.schedulerStopped {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.schedulerCancel = cancel
+	s.schedulerDone = done
+	s.scheduler.Logger = s.log
+
+	go func() {
+		s.scheduler.Run(ctx)
+		close(done)
+	}()
+}
+
+// stopScheduler cancels the runs in progress and waits for them to return until the ctx is done
+func (s *Service) stopScheduler(ctx context.Context) error {
+	s.schedulerMu.Lock()
+	s.schedulerStopped = true
+	cancel, done := s.schedulerCancel, s.schedulerDone
+	s.schedulerMu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("scheduled jobs are abandoned: %v", ctx.Err())
+	}
+}
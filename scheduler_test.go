@@ -0,0 +1,151 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestJobOptsValidate(t *testing.T) {
+	var should = require.New(t)
+
+	should.NoError(JobOpts{Name: "a", Interval: time.Second}.Validate())
+	should.NoError(JobOpts{Name: "a", Cron: "@hourly", Jitter: time.Second, Timeout: time.Second}.Validate())
+
+	should.EqualError(JobOpts{Interval: time.Second}.Validate(), "job name must not be empty")
+	should.EqualError(JobOpts{Name: "a"}.Validate(), "job a: either interval or cron must be set")
+	should.EqualError(JobOpts{Name: "a", Interval: time.Second, Cron: "@hourly"}.Validate(), "job a: either interval or cron must be set")
+	should.EqualError(JobOpts{Name: "a", Cron: "@foo"}.Validate(), `job a: invalid cron expression "@foo": expected 5 fields but got 1`)
+	should.EqualError(JobOpts{Name: "a", Interval: time.Second, Jitter: -1}.Validate(), "job a: jitter must not be negative")
+	should.EqualError(JobOpts{Name: "a", Interval: time.Second, Timeout: -1}.Validate(), "job a: timeout must not be negative")
+
+	sc := NewScheduler()
+	should.EqualError(sc.Add(JobOpts{Name: "a", Interval: time.Second}, nil), "job a must not be nil")
+	should.NoError(sc.Add(JobOpts{Name: "a", Interval: time.Second}, func(ctx context.Context) error { return nil }))
+	should.EqualError(sc.Add(JobOpts{Name: "a", Interval: time.Second}, func(ctx context.Context) error { return nil }), "job a already exists")
+}
+
+func TestScheduler(t *testing.T) {
+	var should = require.New(t)
+
+	logger := &memoryLogger{}
+	sc := NewScheduler()
+	sc.Logger = logger
+
+	// the slow job overlaps with the next run which is skipped
+	var slowRuns int32
+	should.NoError(sc.Add(JobOpts{Name: "test_slow", Interval: 100 * time.Millisecond}, func(ctx context.Context) error {
+		atomic.AddInt32(&slowRuns, 1)
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+
+	// the panics are recovered as failures
+	should.NoError(sc.Add(JobOpts{Name: "test_panic", Interval: 100 * time.Millisecond, Jitter: 10 * time.Millisecond}, func(ctx context.Context) error {
+		panic("boom")
+	}))
+
+	// the run is cancelled after the timeout
+	timeouts := make(chan error, 10)
+	should.NoError(sc.Add(JobOpts{Name: "test_timeout", Interval: 100 * time.Millisecond, Timeout: 10 * time.Millisecond}, func(ctx context.Context) error {
+		<-ctx.Done()
+		timeouts <- ctx.Err()
+		return nil
+	}))
+
+	// the metrics are global
+	slowRunsTotal := testutil.ToFloat64(jobRunsCounter.WithLabelValues("test_slow"))
+	slowSkipped := testutil.ToFloat64(jobSkippedCounter.WithLabelValues("test_slow"))
+	panicFailures := testutil.ToFloat64(jobFailuresCounter.WithLabelValues("test_panic"))
+	timeoutFailures := testutil.ToFloat64(jobFailuresCounter.WithLabelValues("test_timeout"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+	should.NoError(sc.Run(ctx))
+	should.EqualError(sc.Run(ctx), "scheduler is already running")
+
+	// Run waits for the slow job cancelled by the ctx
+	should.Equal(int32(1), atomic.LoadInt32(&slowRuns))
+	should.Equal(slowRunsTotal+1, testutil.ToFloat64(jobRunsCounter.WithLabelValues("test_slow")))
+	should.True(testutil.ToFloat64(jobSkippedCounter.WithLabelValues("test_slow")) >= slowSkipped+1)
+
+	should.True(testutil.ToFloat64(jobFailuresCounter.WithLabelValues("test_panic")) >= panicFailures+2)
+	should.Equal(context.DeadlineExceeded, <-timeouts)
+	should.Equal(timeoutFailures, testutil.ToFloat64(jobFailuresCounter.WithLabelValues("test_timeout")))
+
+	messages := strings.Join(logger.messages, "\n")
+	should.Contains(messages, "Job test_slow is skipped since the previous run is still in progress")
+	should.Contains(messages, "Job test_panic panicked: boom")
+	should.Contains(messages, "Job test_panic failed: panic: boom")
+
+	should.EqualError(sc.Add(JobOpts{Name: "b", Interval: time.Second}, func(ctx context.Context) error { return nil }), "job b can not be added to the running scheduler")
+}
+
+func TestScheduleJob(t *testing.T) {
+	var should = require.New(t)
+
+	_, err := New(ScheduleJob(JobOpts{Name: "a"}, func(ctx context.Context) error { return nil }))
+	should.EqualError(err, "job a: either interval or cron must be set")
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	s := NewService(
+		PreShutdownDelay(0),
+		ScheduleJob(JobOpts{Name: "test_service", Interval: 10 * time.Millisecond}, func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+			return errors.New("cancelled")
+		}),
+	)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Start(23888, 23999, noopReverseProxyFunc)
+	}()
+
+	// the job runs once the service starts and is cancelled on shutdown
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		should.FailNow("job is not started")
+	}
+	should.NoError(s.Shutdown(context.Background()))
+	should.NoError(<-stopped)
+	<-cancelled
+}
+
+func TestScheduleJobServeError(t *testing.T) {
+	var should = require.New(t)
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	s := NewService(
+		ScheduleJob(JobOpts{Name: "test_serve_error", Interval: 10 * time.Millisecond}, func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+			return nil
+		}),
+	)
+
+	// the job is cancelled when Start returns without the shutdown
+	err := s.Start(10888, 10999, func(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error {
+		<-started
+		return errors.New("reverse proxy func error")
+	})
+	should.EqualError(err, "reverse proxy func error")
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		should.Fail("job is not cancelled")
+	}
+}
@@ -0,0 +1,183 @@
+package micro
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// ServerConfig is the connection and size limits of both gRPC and http servers
+type ServerConfig struct {
+	// KeepaliveTime is the idle time after which the gRPC server pings the client
+	KeepaliveTime time.Duration `yaml:"keepalive_time" json:"keepalive_time"`
+	// KeepaliveTimeout is the time waiting for the ping ack before the connection is closed
+	KeepaliveTimeout time.Duration `yaml:"keepalive_timeout" json:"keepalive_timeout"`
+	// KeepaliveMinTime is the minimum interval of the client pings, the clients pinging more
+	// frequently are disconnected
+	KeepaliveMinTime time.Duration `yaml:"keepalive_min_time" json:"keepalive_min_time"`
+	// KeepalivePermitWithoutStream allows the client pings when there are no active streams
+	KeepalivePermitWithoutStream bool `yaml:"keepalive_permit_without_stream" json:"keepalive_permit_without_stream"`
+	// MaxConnectionIdle is the idle time after which the gRPC connection is closed, zero means infinity
+	MaxConnectionIdle time.Duration `yaml:"max_connection_idle" json:"max_connection_idle"`
+	// MaxConnectionAge is the maximum age of the gRPC connection, zero means infinity
+	MaxConnectionAge time.Duration `yaml:"max_connection_age" json:"max_connection_age"`
+	// MaxConnectionAgeGrace is the time for the pending RPCs to complete after MaxConnectionAge
+	MaxConnectionAgeGrace time.Duration `yaml:"max_connection_age_grace" json:"max_connection_age_grace"`
+	// MaxConcurrentStreams is the maximum number of concurrent streams of each gRPC connection,
+	// zero means unlimited
+	MaxConcurrentStreams uint32 `yaml:"max_concurrent_streams" json:"max_concurrent_streams"`
+	// MaxRecvMsgSize is the maximum size of the gRPC messages received by the server, it also
+	// limits the size of the http request bodies
+	MaxRecvMsgSize int `yaml:"max_recv_msg_size" json:"max_recv_msg_size"`
+	// MaxSendMsgSize is the maximum size of the gRPC messages sent by the server, the gateway is
+	// able to receive the messages of this size
+	MaxSendMsgSize int `yaml:"max_send_msg_size" json:"max_send_msg_size"`
+	// ReadTimeout is the ReadTimeout of the http server, zero means no timeout
+	ReadTimeout time.Duration `yaml:"read_timeout" json:"read_timeout"`
+	// ReadHeaderTimeout is the ReadHeaderTimeout of the http server
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" json:"read_header_timeout"`
+	// WriteTimeout is the WriteTimeout of the http server, zero means no timeout which is required
+	// by the streaming responses
+	WriteTimeout time.Duration `yaml:"write_timeout" json:"write_timeout"`
+	// IdleTimeout is the IdleTimeout of the http server
+	IdleTimeout time.Duration `yaml:"idle_timeout" json:"idle_timeout"`
+	// MaxHeaderBytes is the MaxHeaderBytes of the http server
+	MaxHeaderBytes int `yaml:"max_header_bytes" json:"max_header_bytes"`
+}
+
+// DefaultServerConfig returns the default server config for production
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		KeepaliveTime:                time.Minute,
+		KeepaliveTimeout:             20 * time.Second,
+		KeepaliveMinTime:             10 * time.Second,
+		KeepalivePermitWithoutStream: true,
+		MaxRecvMsgSize:               4 << 20,
+		MaxSendMsgSize:               math.MaxInt32,
+		ReadHeaderTimeout:            10 * time.Second,
+		IdleTimeout:                  2 * time.Minute,
+		MaxHeaderBytes:               http.DefaultMaxHeaderBytes,
+	}
+}
+
+// Validate validates the server config
+func (c ServerConfig) Validate() error {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"keepalive_time", c.KeepaliveTime},
+		{"keepalive_timeout", c.KeepaliveTimeout},
+		{"keepalive_min_time", c.KeepaliveMinTime},
+		{"max_connection_idle", c.MaxConnectionIdle},
+		{"max_connection_age", c.MaxConnectionAge},
+		{"max_connection_age_grace", c.MaxConnectionAgeGrace},
+		{"read_timeout", c.ReadTimeout},
+		{"read_header_timeout", c.ReadHeaderTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return fmt.Errorf("%s must not be negative", d.name)
+		}
+	}
+
+	if c.MaxRecvMsgSize <= 0 {
+		return errors.New("max_recv_msg_size must be positive")
+	}
+
+	if c.MaxSendMsgSize <= 0 {
+		return errors.New("max_send_msg_size must be positive")
+	}
+
+	if c.MaxHeaderBytes < 0 {
+		return errors.New("max_header_bytes must not be negative")
+	}
+
+	if c.MaxConnectionAgeGrace > 0 && c.MaxConnectionAge == 0 {
+		return errors.New("max_connection_age_grace requires max_connection_age")
+	}
+
+	if c.ReadTimeout > 0 && c.ReadHeaderTimeout > c.ReadTimeout {
+		return errors.New("read_header_timeout must not exceed read_timeout")
+	}
+
+	return nil
+}
+
+// grpcServerOptions returns the gRPC server options of the config
+func (c ServerConfig) grpcServerOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:                  c.KeepaliveTime,
+			Timeout:               c.KeepaliveTimeout,
+			MaxConnectionIdle:     c.MaxConnectionIdle,
+			MaxConnectionAge:      c.MaxConnectionAge,
+			MaxConnectionAgeGrace: c.MaxConnectionAgeGrace,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             c.KeepaliveMinTime,
+			PermitWithoutStream: c.KeepalivePermitWithoutStream,
+		}),
+		grpc.MaxRecvMsgSize(c.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(c.MaxSendMsgSize),
+	}
+
+	if c.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(c.MaxConcurrentStreams))
+	}
+
+	return opts
+}
+
+// grpcDialOptions returns the dial options for the gateway so that it accepts the same message sizes
+// as the gRPC server
+func (c ServerConfig) grpcDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallSendMsgSize(c.MaxRecvMsgSize),
+			grpc.MaxCallRecvMsgSize(c.MaxSendMsgSize),
+		),
+	}
+}
+
+// applyHTTPServer sets the http server fields which are not set yet
+func (c ServerConfig) applyHTTPServer(server *http.Server) {
+	if server.ReadTimeout == 0 {
+		server.ReadTimeout = c.ReadTimeout
+	}
+	if server.ReadHeaderTimeout == 0 {
+		server.ReadHeaderTimeout = c.ReadHeaderTimeout
+	}
+	if server.WriteTimeout == 0 {
+		server.WriteTimeout = c.WriteTimeout
+	}
+	if server.IdleTimeout == 0 {
+		server.IdleTimeout = c.IdleTimeout
+	}
+	if server.MaxHeaderBytes == 0 {
+		server.MaxHeaderBytes = c.MaxHeaderBytes
+	}
+}
+
+// limitBody limits the size of the http request bodies to the size of the gRPC messages
+func (c ServerConfig) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > int64(c.MaxRecvMsgSize) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, int64(c.MaxRecvMsgSize))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+package micro
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerConfigValidate(t *testing.T) {
+	var should = require.New(t)
+
+	should.NoError(DefaultServerConfig().Validate())
+
+	config := DefaultServerConfig()
+	config.KeepaliveTime = -time.Second
+	should.EqualError(config.Validate(), "keepalive_time must not be negative")
+
+	config = DefaultServerConfig()
+	config.MaxRecvMsgSize = 0
+	should.EqualError(config.Validate(), "max_recv_msg_size must be positive")
+
+	config = DefaultServerConfig()
+	config.MaxConnectionAgeGrace = time.Second
+	should.EqualError(config.Validate(), "max_connection_age_grace requires max_connection_age")
+
+	config = DefaultServerConfig()
+	config.ReadTimeout = time.Second
+	should.EqualError(config.Validate(), "read_header_timeout must not exceed read_timeout")
+}
+
+func TestWithServerConfig(t *testing.T) {
+	var should = require.New(t)
+
+	config := DefaultServerConfig()
+	config.MaxConcurrentStreams = 100
+	config.WriteTimeout = time.Minute
+
+	s := NewService(
+		WithServerConfig(config),
+		WithHTTPServer(&http.Server{WriteTimeout: time.Second}),
+	)
+	should.Equal(config, s.serverConfig)
+	should.Len(s.serverConfig.grpcServerOptions(), 5)
+	should.Len(s.serverConfig.grpcDialOptions(), 1)
+
+	// the explicit fields of the http server are kept
+	should.Equal(time.Second, s.HTTPServer.WriteTimeout)
+	should.Equal(config.ReadHeaderTimeout, s.HTTPServer.ReadHeaderTimeout)
+	should.Equal(config.IdleTimeout, s.HTTPServer.IdleTimeout)
+
+	// the invalid config is rejected
+	config.MaxSendMsgSize = -1
+	should.PanicsWithError("invalid server config: max_send_msg_size must be positive", func() {
+		NewService(WithServerConfig(config))
+	})
+}
+
+func TestLimitBody(t *testing.T) {
+	var should = require.New(t)
+
+	config := DefaultServerConfig()
+	config.MaxRecvMsgSize = 4
+
+	handler := config.limitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 16)
+		if _, err := r.Body.Read(buf); err != nil && err.Error() == "http: request body too large" {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/", strings.NewReader("abc")))
+	should.Equal(http.StatusOK, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/", strings.NewReader("too large")))
+	should.Equal(http.StatusRequestEntityTooLarge, recorder.Code)
+
+	// the body without content length is limited by the reader
+	req := httptest.NewRequest("POST", "/", strings.NewReader("too large"))
+	req.ContentLength = -1
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	should.Equal(http.StatusRequestEntityTooLarge, recorder.Code)
+}
@@ -0,0 +1,78 @@
+package micro
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestShutdownTwice(t *testing.T) {
+	var should = require.New(t)
+
+	calls := 0
+	s := NewService(
+		PreShutdownDelay(0),
+		OnStop(func(ctx context.Context) error {
+			calls++
+			return nil
+		}, 0),
+	)
+
+	// shutdown before start and concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			should.NoError(s.Shutdown(context.Background()))
+		}()
+	}
+	wg.Wait()
+	should.NoError(s.Shutdown(context.Background()))
+	should.Equal(1, calls)
+
+	should.Equal(ErrServiceStopped, s.Start(28888, 29999, reverseProxyFunc))
+}
+
+func TestShutdownForcibly(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(
+		PreShutdownDelay(0),
+		ShutdownTimeout(100*time.Millisecond),
+	)
+	healthpb.RegisterHealthServer(s.GRPCServer, health.NewServer())
+
+	started := make(chan error, 1)
+	go func() {
+		started <- s.Start(48888, 49999, func(ctx context.Context, mux *runtime.ServeMux, grpcHostAndPort string, opts []grpc.DialOption) error {
+			return nil
+		})
+	}()
+
+	// open a stream which never ends
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "localhost:49999", grpc.WithInsecure(), grpc.WithBlock())
+	should.NoError(err)
+	defer conn.Close()
+
+	stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	should.NoError(err)
+	_, err = stream.Recv()
+	should.NoError(err)
+
+	err = s.Shutdown(context.Background())
+	should.Error(err)
+	should.Contains(err.Error(), "gRPC server is stopped forcibly: context deadline exceeded")
+
+	// Start returns the result of Shutdown
+	should.Equal(err, <-started)
+}
@@ -1,14 +1,225 @@
 package micro
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"runtime"
 	"syscall"
 )
 
-// InterruptSignals are the default interrupt signals to catch
+// SignalAction is the action taken by the service on a signal, see Signal
+type SignalAction int
+
+const (
+	// SignalStop stops the service gracefully, the service stops immediately on the second signal
+	SignalStop SignalAction = iota + 1
+	// SignalStopNow stops the service immediately, the servers are stopped forcibly without draining
+	SignalStopNow
+	// SignalReload reloads the config of the service created from a config, see Reload
+	SignalReload
+	// SignalRestart restarts the service gracefully, see GracefulRestart
+	SignalRestart
+	// SignalDump dumps the state and the goroutines of the service to the logger
+	SignalDump
+	// SignalToggleDebug switches the log level between debug and the level before
+	SignalToggleDebug
+)
+
+// signalActionNames are the names of the actions in the logs
+var signalActionNames = map[SignalAction]string{
+	SignalStop:        "stop",
+	SignalStopNow:     "stop now",
+	SignalReload:      "reload",
+	SignalRestart:     "restart",
+	SignalDump:        "dump",
+	SignalToggleDebug: "toggle debug",
+}
+
+// String implements fmt.Stringer interface
+func (a SignalAction) String() string {
+	if name, ok := signalActionNames[a]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("SignalAction(%d)", int(a))
+}
+
+// InterruptSignals are the default signals to stop the service, use the Signal or Signals option to change
+// them per service
 var InterruptSignals = []os.Signal{
-	syscall.SIGSTOP,
 	syscall.SIGINT,
 	syscall.SIGTERM,
 	syscall.SIGQUIT,
 }
+
+// ReloadSignals are the default signals to reload the config of the service created from a config, use
+// the Signal or Signals option to change them per service
+var ReloadSignals = []os.Signal{
+	syscall.SIGHUP,
+}
+
+// defaultSignalActions maps InterruptSignals to SignalStop and ReloadSignals to SignalReload
+func defaultSignalActions() map[os.Signal]SignalAction {
+	actions := make(map[os.Signal]SignalAction)
+	for _, sig := range InterruptSignals {
+		actions[sig] = SignalStop
+	}
+	for _, sig := range ReloadSignals {
+		actions[sig] = SignalReload
+	}
+
+	return actions
+}
+
+// validateSignal checks if the signal can be caught and the action is known
+func validateSignal(sig os.Signal, action SignalAction) error {
+	if sig == nil {
+		return fmt.Errorf("signal must not be nil")
+	}
+	for _, uncatchable := range uncatchableSignals {
+		if sig == uncatchable {
+			return fmt.Errorf("signal %v can not be caught", sig)
+		}
+	}
+	if _, ok := signalActionNames[action]; !ok {
+		return fmt.Errorf("invalid action of signal %v: %v", sig, action)
+	}
+
+	return nil
+}
+
+// signalsToNotify returns the signals to catch, the reload signals are not caught unless the service is
+// created from a config
+func (s *Service) signalsToNotify() []os.Signal {
+	var signals []os.Signal
+	for sig, action := range s.signalActions {
+		if action == SignalReload && s.config == nil {
+			continue
+		}
+		signals = append(signals, sig)
+	}
+
+	return signals
+}
+
+// signalHandler takes the actions of the signals caught by Start, the shutdown started by the signals runs
+// in the background so that the second stop signal can force it
+type signalHandler struct {
+	s          *Service
+	ctx        context.Context
+	force      context.CancelFunc
+	stopping   bool
+	done       chan error
+	restarting bool
+	restarted  chan restartResult
+}
+
+// restartResult is the result of the restart running in the background
+type restartResult struct {
+	process *os.Process
+	err     error
+}
+
+func (s *Service) newSignalHandler() *signalHandler {
+	ctx, force := context.WithCancel(context.Background())
+
+	return &signalHandler{
+		s:         s,
+		ctx:       ctx,
+		force:     force,
+		done:      make(chan error, 1),
+		restarted: make(chan restartResult, 1),
+	}
+}
+
+// handle takes the action of the signal
+func (h *signalHandler) handle(sig os.Signal) {
+	s := h.s
+	action := s.signalActions[sig]
+	s.logger.Printf("Signal received: %v, action: %v", sig, action)
+
+	switch action {
+	case SignalStop:
+		if h.stopping {
+			s.log.logf(logLevelWarn, "Second stop signal received, stopping immediately")
+			h.force()
+			return
+		}
+		h.stop()
+
+	case SignalStopNow:
+		h.force()
+		h.stop()
+
+	case SignalReload:
+		// the service keeps running with the old config if it fails
+		if err := s.Reload(); err != nil {
+			s.log.logf(logLevelWarn, "Failed to reload config: %v", err)
+		}
+
+	case SignalRestart:
+		// the new process is waited in the background so that the other signals are handled meanwhile
+		if h.stopping || h.restarting {
+			return
+		}
+		h.restarting = true
+		go func() {
+			process, err := s.restartProcess()
+			h.restarted <- restartResult{process: process, err: err}
+		}()
+
+	case SignalDump:
+		s.dump()
+
+	case SignalToggleDebug:
+		// logged at the warn level so that it is seen at any level
+		if s.log.toggleDebug() {
+			s.log.logf(logLevelWarn, "Debug logging is enabled")
+		} else {
+			s.log.logf(logLevelWarn, "Debug logging is disabled")
+		}
+	}
+}
+
+// finishRestart stops the service once the new process is ready or keeps it running if the restart fails
+func (h *signalHandler) finishRestart(result restartResult) {
+	h.restarting = false
+	if result.err != nil {
+		h.s.log.logf(logLevelWarn, "Failed to restart: %v", result.err)
+		return
+	}
+
+	h.s.logger.Printf("New process %d is ready, shutdown starts", result.process.Pid)
+	h.stop()
+}
+
+// stop starts the shutdown once
+func (h *signalHandler) stop() {
+	if h.stopping {
+		return
+	}
+	h.stopping = true
+
+	go func() {
+		h.done <- h.s.Shutdown(h.ctx)
+	}()
+}
+
+// dump logs the state and the stacks of all goroutines
+func (s *Service) dump() {
+	grpcInflight, httpInflight := s.inflight()
+	s.logger.Printf("State: ready=%v, shutdown=%v, in-flight gRPC requests=%d, in-flight http requests=%d, goroutines=%d",
+		s.Ready(), s.isShutdown(), grpcInflight, httpInflight, runtime.NumGoroutine())
+
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	s.logger.Printf("Goroutines:\n%s", buf)
+}
@@ -0,0 +1,125 @@
+package micro
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignals(t *testing.T) {
+	var should = require.New(t)
+
+	// the reload signals are not caught without config
+	s := NewService()
+	should.Equal(SignalReload, s.signalActions[syscall.SIGHUP])
+	should.ElementsMatch(InterruptSignals, s.signalsToNotify())
+
+	s, err := New(
+		Signals(map[os.Signal]SignalAction{syscall.SIGTERM: SignalStop}),
+		Signal(syscall.SIGUSR1, SignalDump),
+		Signal(syscall.SIGTERM, SignalStopNow),
+	)
+	should.NoError(err)
+	should.Equal(map[os.Signal]SignalAction{syscall.SIGTERM: SignalStopNow, syscall.SIGUSR1: SignalDump}, s.signalActions)
+
+	_, err = New(Signal(syscall.SIGSTOP, SignalStop))
+	should.EqualError(err, "signal stopped (signal) can not be caught")
+
+	_, err = New(Signals(map[os.Signal]SignalAction{syscall.SIGUSR1: 0}))
+	should.EqualError(err, "invalid action of signal user defined signal 1: SignalAction(0)")
+
+	_, err = New(Signals(map[os.Signal]SignalAction{}))
+	should.EqualError(err, "signals must not be empty")
+
+	// nothing is caught with only the reload signals and no config
+	s, err = New(Signals(map[os.Signal]SignalAction{syscall.SIGHUP: SignalReload}))
+	should.NoError(err)
+	should.Empty(s.signalsToNotify())
+
+	_, err = New(GracefulRestart(nil, 0))
+	should.EqualError(err, "signal must not be nil")
+}
+
+func TestSignalDumpAndToggleDebug(t *testing.T) {
+	var should = require.New(t)
+
+	logger := &memoryLogger{}
+	s := NewService(WithLogger(logger), LogLevel("warn"))
+	h := s.newSignalHandler()
+	defer h.force()
+
+	s.signalActions[syscall.SIGUSR1] = SignalToggleDebug
+	s.signalActions[syscall.SIGUSR2] = SignalDump
+
+	// debug is toggled on from warn and back
+	h.handle(syscall.SIGUSR1)
+	should.Equal(logLevelDebug, s.log.level)
+	h.handle(syscall.SIGUSR1)
+	should.Equal(logLevelWarn, s.log.level)
+
+	// the info logs are seen while debug is on
+	should.Equal([]string{
+		"Debug logging is enabled",
+		"Signal received: user defined signal 1, action: toggle debug",
+		"Debug logging is disabled",
+	}, logger.messages)
+
+	// the dump is dropped at warn level
+	h.handle(syscall.SIGUSR2)
+	should.Len(logger.messages, 3)
+
+	h.handle(syscall.SIGUSR1)
+	h.handle(syscall.SIGUSR2)
+	messages := strings.Join(logger.messages, "\n")
+	should.Contains(messages, "State: ready=false, shutdown=false")
+	should.Contains(messages, "TestSignalDumpAndToggleDebug")
+}
+
+func TestSecondStopSignal(t *testing.T) {
+	var should = require.New(t)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	s := NewService(
+		RouteOpt(Route{
+			Method: "GET",
+			Path:   "/block",
+			Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+				<-release
+			},
+		}),
+		PreShutdownDelay(10*time.Second),
+	)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Start(25888, 25999, noopReverseProxyFunc)
+	}()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	// the request blocks the drain
+	go http.Get("http://127.0.0.1:25888/block")
+	should.Eventually(func() bool {
+		_, httpInflight := s.inflight()
+		return httpInflight == 1
+	}, time.Second, 10*time.Millisecond)
+
+	should.NoError(syscall.Kill(os.Getpid(), syscall.SIGINT))
+	should.Eventually(func() bool { return !s.Ready() }, time.Second, 10*time.Millisecond)
+
+	// the second signal stops the service without waiting for the drain
+	start := time.Now()
+	should.NoError(syscall.Kill(os.Getpid(), syscall.SIGINT))
+	err := <-stopped
+	should.Error(err)
+	should.Contains(err.Error(), "http server is closed forcibly: context canceled")
+	should.True(time.Since(start) < 5*time.Second)
+}
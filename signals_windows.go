@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package micro
+
+import (
+	"os"
+	"syscall"
+)
+
+// uncatchableSignals are the signals which can not be caught
+var uncatchableSignals = []os.Signal{syscall.SIGKILL}
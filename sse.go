@@ -0,0 +1,287 @@
+package micro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// LastEventIDMetadataKey is the metadata key of Last-Event-ID sent by the reconnecting SSE clients, the
+// streaming methods can resume after it
+const LastEventIDMetadataKey = "last-event-id"
+
+const (
+	sseContentType = "text/event-stream"
+	// the event carrying the final status of the call
+	sseStatusEvent = "status"
+)
+
+// SSEOpts is configures for Server-Sent Events. Each message is sent as an event and the final status is sent
+// as the status event, Last-Event-ID is passed to the methods as the metadata of LastEventIDMetadataKey
+type SSEOpts struct {
+	// HeartbeatInterval is the interval of the comments sent to keep the idle streams alive through the
+	// proxies, default is 15 seconds
+	HeartbeatInterval time.Duration
+}
+
+// ensureDefaults sets the default values if not set
+func (o *SSEOpts) ensureDefaults() {
+	if o.HeartbeatInterval == 0 {
+		o.HeartbeatInterval = 15 * time.Second
+	}
+}
+
+// isSSERequest checks if the request accepts text/event-stream
+func isSSERequest(r *http.Request) bool {
+	for _, value := range r.Header["Accept"] {
+		for _, v := range strings.Split(value, ",") {
+			if mediaType, _, err := mime.ParseMediaType(v); err == nil && mediaType == sseContentType {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// lastEventIDAnnotator passes Last-Event-ID to the gRPC methods as the metadata
+func lastEventIDAnnotator(ctx context.Context, r *http.Request) metadata.MD {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return metadata.Pairs(LastEventIDMetadataKey, id)
+	}
+
+	return nil
+}
+
+// sseMiddleware serves the requests accepting text/event-stream as Server-Sent Events, other requests are
+// passed to the next. Each message of the gateway response is sent as an event whose id follows the numeric
+// Last-Event-ID, the call ends with the status event carrying the status in JSON
+func (s *Service) sseMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isSSERequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		ctx, ok := s.grpcHTTP.begin(r.Context())
+		if !ok {
+			http.Error(w, "service is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.grpcHTTP.end()
+
+		header := w.Header()
+		header.Set("Content-Type", sseContentType)
+		header.Set("Cache-Control", "no-cache")
+		// disable the response buffering of nginx
+		header.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		sw := &sseResponseWriter{w: w, flusher: flusher, header: make(http.Header)}
+		sw.id, _ = strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+		ctx = context.WithValue(ctx, sseWriterKey{}, sw)
+
+		heartbeatDone := make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(s.sse.HeartbeatInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					sw.heartbeat()
+				case <-heartbeatDone:
+					return
+				}
+			}
+		}()
+
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		close(heartbeatDone)
+
+		if s.grpcHTTP.isClosed() {
+			sw.finish(status.New(codes.Unavailable, "service is shutting down"))
+		} else {
+			sw.finish(nil)
+		}
+	})
+}
+
+// sseResponseWriter converts the gateway response into the events, the chunks of streaming responses are sent
+// once flushed and the unary response is sent at the end
+type sseResponseWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	header  http.Header
+	code    int
+	// the response is a stream once flushed
+	streaming bool
+	buf       []byte
+	id        int64
+	// the status in JSON of the error chunk or the error response
+	status json.RawMessage
+	// the status of the error response recorded by the error handler, it takes precedence over the body
+	// which is rendered by the error handler in any format
+	errorStatus *status.Status
+	err         error
+}
+
+// sseWriterKey is the context key of the sseResponseWriter of the request
+type sseWriterKey struct{}
+
+// sseErrorHandler records the status of the error response of the SSE request before the error handler
+// renders it, so that the status event does not depend on the format of the error handler
+func sseErrorHandler(next runtime.ErrorHandlerFunc) runtime.ErrorHandlerFunc {
+	return func(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+		if sw, ok := r.Context().Value(sseWriterKey{}).(*sseResponseWriter); ok {
+			sw.mu.Lock()
+			sw.errorStatus = status.Convert(err)
+			sw.mu.Unlock()
+		}
+		next(ctx, mux, marshaler, w, r, err)
+	}
+}
+
+// Header implements http.ResponseWriter interface, the headers are sent before the gateway responds
+func (sw *sseResponseWriter) Header() http.Header {
+	return sw.header
+}
+
+// WriteHeader implements http.ResponseWriter interface
+func (sw *sseResponseWriter) WriteHeader(code int) {
+	if sw.code == 0 {
+		sw.code = code
+	}
+}
+
+// Write implements http.ResponseWriter interface, it fails once the connection fails so that the gateway
+// stops streaming
+func (sw *sseResponseWriter) Write(b []byte) (int, error) {
+	sw.WriteHeader(http.StatusOK)
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	sw.buf = append(sw.buf, b...)
+
+	return len(b), nil
+}
+
+// Flush implements http.Flusher interface which is required by the gateway to stream
+func (sw *sseResponseWriter) Flush() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.streaming = true
+	sw.send()
+}
+
+// send sends the buffered chunk as an event, the result of the stream chunk is unwrapped and the error chunk
+// is kept for the status event
+func (sw *sseResponseWriter) send() {
+	chunk := bytes.TrimSuffix(sw.buf, []byte("\n"))
+	sw.buf = nil
+	if len(chunk) == 0 {
+		return
+	}
+
+	if sw.streaming || sw.code >= http.StatusBadRequest {
+		var c struct {
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+		}
+		if json.Unmarshal(chunk, &c) == nil {
+			switch {
+			case len(c.Error) > 0:
+				sw.status = c.Error
+				return
+			case len(c.Result) > 0:
+				chunk = c.Result
+			}
+		}
+	}
+	if sw.code >= http.StatusBadRequest {
+		sw.status = chunk
+		return
+	}
+
+	sw.id++
+	sw.writeEvent(strconv.FormatInt(sw.id, 10), "", chunk)
+}
+
+// writeEvent writes the event, each line of the data is a data field
+func (sw *sseResponseWriter) writeEvent(id string, event string, data []byte) {
+	if sw.err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	if id != "" {
+		buf.WriteString("id: " + id + "\n")
+	}
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	_, sw.err = sw.w.Write(buf.Bytes())
+	sw.flusher.Flush()
+}
+
+// heartbeat writes a comment which is ignored by the clients
+func (sw *sseResponseWriter) heartbeat() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	if sw.err == nil {
+		_, sw.err = sw.w.Write([]byte(": heartbeat\n\n"))
+		sw.flusher.Flush()
+	}
+}
+
+// finish sends the pending response and the status event, the status overrides the one of the response
+func (sw *sseResponseWriter) finish(st *status.Status) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	sw.send()
+
+	if st == nil && sw.code >= http.StatusBadRequest {
+		st = sw.errorStatus
+	}
+	data := []byte(sw.status)
+	if st != nil || data == nil {
+		if st == nil {
+			st = status.New(codes.OK, "")
+		}
+		data, _ = protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(st.Proto())
+	}
+	sw.writeEvent("", sseStatusEvent, data)
+}
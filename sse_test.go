@@ -0,0 +1,242 @@
+package micro
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// sseEvent is an event or a comment read from the stream
+type sseEvent struct {
+	id      string
+	event   string
+	data    string
+	comment string
+}
+
+// readSSEEvent reads the next event or comment
+func readSSEEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	var e sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return e
+		}
+
+		field := strings.SplitN(line, ": ", 2)
+		switch field[0] {
+		case "":
+			e.comment = field[1]
+		case "id":
+			e.id = field[1]
+		case "event":
+			e.event = field[1]
+		case "data":
+			e.data += field[1]
+		}
+	}
+}
+
+// ticksRoute streams 2 ticks following the last event id like a server streaming method of the gateway, the
+// call fails if the query fail is set, or blocks until cancelled if the query block is set
+func ticksRoute(s *Service) Route {
+	return Route{
+		Method: "GET",
+		Path:   "/v1/ticks",
+		Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			_, marshaler := runtime.MarshalerForRequest(s.mux, r)
+			ctx, err := runtime.AnnotateContext(r.Context(), s.mux, r, "/test.Ticks/Watch")
+			if err != nil {
+				runtime.HTTPError(ctx, s.mux, marshaler, w, r, err)
+				return
+			}
+
+			var last int
+			if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(LastEventIDMetadataKey)) > 0 {
+				last, _ = strconv.Atoi(md.Get(LastEventIDMetadataKey)[0])
+			}
+
+			fail, err := strconv.Atoi(r.URL.Query().Get("fail"))
+			if err != nil {
+				fail = -1
+			}
+
+			var sent int
+			recv := func() (proto.Message, error) {
+				if sent == fail {
+					return nil, status.Error(codes.FailedPrecondition, "ticks failed")
+				}
+				if sent == 2 {
+					if r.URL.Query().Get("block") != "" {
+						<-ctx.Done()
+						return nil, ctx.Err()
+					}
+					return nil, io.EOF
+				}
+				sent++
+				return structpb.NewStruct(map[string]interface{}{"tick": last + sent})
+			}
+
+			ctx = runtime.NewServerMetadataContext(ctx, runtime.ServerMetadata{})
+			runtime.ForwardResponseStream(ctx, s.mux, marshaler, w, r, recv)
+		},
+	}
+}
+
+// getSSE requests the path accepting text/event-stream
+func getSSE(t *testing.T, url string, lastEventID string) *http.Response {
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func TestSSE(t *testing.T) {
+	var should = require.New(t)
+
+	_, err := New(SSE(SSEOpts{HeartbeatInterval: -1}))
+	should.EqualError(err, "sse heartbeat interval must not be negative")
+
+	s := NewService(SSE(SSEOpts{}), PreShutdownDelay(0))
+	s.AddRoutes(ticksRoute(s))
+	go s.Start(14888, 14999, noopReverseProxyFunc)
+	defer s.Stop()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	// the ids follow Last-Event-ID which is passed as the metadata
+	resp := getSSE(t, "http://127.0.0.1:14888/v1/ticks", "3")
+	should.Equal(http.StatusOK, resp.StatusCode)
+	should.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+	should.Equal(sseEvent{id: "4", data: `{"tick":4}`}, readSSEEvent(t, reader))
+	should.Equal(sseEvent{id: "5", data: `{"tick":5}`}, readSSEEvent(t, reader))
+	e := readSSEEvent(t, reader)
+	should.Equal("status", e.event)
+	should.JSONEq(`{"code":0,"message":"","details":[]}`, e.data)
+	resp.Body.Close()
+
+	// the error in the middle of the stream
+	resp = getSSE(t, "http://127.0.0.1:14888/v1/ticks?fail=1", "")
+	reader = bufio.NewReader(resp.Body)
+	should.Equal(sseEvent{id: "1", data: `{"tick":1}`}, readSSEEvent(t, reader))
+	e = readSSEEvent(t, reader)
+	should.Equal("status", e.event)
+	should.JSONEq(`{"code":9,"message":"ticks failed","details":[]}`, e.data)
+	resp.Body.Close()
+
+	// the error before the stream
+	resp = getSSE(t, "http://127.0.0.1:14888/v1/ticks?fail=0", "")
+	reader = bufio.NewReader(resp.Body)
+	e = readSSEEvent(t, reader)
+	should.Equal("status", e.event)
+	should.JSONEq(`{"code":9,"message":"ticks failed","details":[]}`, e.data)
+	resp.Body.Close()
+
+	// the error response of the unknown route
+	resp = getSSE(t, "http://127.0.0.1:14888/v1/unknown", "")
+	reader = bufio.NewReader(resp.Body)
+	e = readSSEEvent(t, reader)
+	should.Equal("status", e.event)
+	should.Contains(e.data, `"code":5`)
+	resp.Body.Close()
+
+	// other requests are not affected
+	resp, err = http.Get("http://127.0.0.1:14888/v1/ticks")
+	should.NoError(err)
+	resp.Body.Close()
+	should.Equal("application/json", resp.Header.Get("Content-Type"))
+}
+
+func TestSSEProblemJSON(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(SSE(SSEOpts{}), ErrorHandler(ProblemJSONErrorHandler), PreShutdownDelay(0))
+	s.AddRoutes(ticksRoute(s))
+	go s.Start(33888, 33999, noopReverseProxyFunc)
+	defer s.Stop()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	// the status event of the error response is the status whatever the error handler renders
+	resp := getSSE(t, "http://127.0.0.1:33888/v1/unknown", "")
+	reader := bufio.NewReader(resp.Body)
+	e := readSSEEvent(t, reader)
+	should.Equal("status", e.event)
+	should.JSONEq(`{"code":5,"message":"Not Found","details":[]}`, e.data)
+	resp.Body.Close()
+
+	// the error chunk of the stream is not rendered by the error handler
+	resp = getSSE(t, "http://127.0.0.1:33888/v1/ticks?fail=0", "")
+	reader = bufio.NewReader(resp.Body)
+	e = readSSEEvent(t, reader)
+	should.Equal("status", e.event)
+	should.JSONEq(`{"code":9,"message":"ticks failed","details":[]}`, e.data)
+	resp.Body.Close()
+}
+
+func TestSSEShutdown(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(SSE(SSEOpts{HeartbeatInterval: 50 * time.Millisecond}), PreShutdownDelay(0))
+	s.AddRoutes(ticksRoute(s))
+	go s.Start(13888, 13999, noopReverseProxyFunc)
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequest("GET", "http://127.0.0.1:13888/v1/ticks?block=1", nil)
+	should.NoError(err)
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	should.NoError(err)
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	should.Equal("1", readSSEEvent(t, reader).id)
+	should.Equal("2", readSSEEvent(t, reader).id)
+
+	// the heartbeats are sent while the stream is idle
+	should.Equal(sseEvent{comment: "heartbeat"}, readSSEEvent(t, reader))
+
+	// the stream in flight ends with unavailable on shutdown
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+	for {
+		e := readSSEEvent(t, reader)
+		if e.event == "status" {
+			should.JSONEq(`{"code":14,"message":"service is shutting down","details":[]}`, e.data)
+			break
+		}
+	}
+	should.NoError(<-done)
+}
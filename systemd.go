@@ -0,0 +1,144 @@
+package micro
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the first file descriptor passed by systemd socket activation, it is a variable so that the tests do not
+// take the file descriptors used by the runtime
+var sdListenFDsStart = 3
+
+// the listeners passed by systemd, each of them can be taken once
+var (
+	systemdOnce      sync.Once
+	systemdMu        sync.Mutex
+	systemdListeners map[string]net.Listener
+	systemdErr       error
+)
+
+// systemdListener takes the listener named by FileDescriptorName= of the systemd socket unit, it
+// returns nil if not passed
+func systemdListener(name string) (net.Listener, error) {
+	systemdOnce.Do(func() {
+		systemdListeners, systemdErr = systemdListenersFromEnv()
+	})
+
+	systemdMu.Lock()
+	defer systemdMu.Unlock()
+
+	lis := systemdListeners[name]
+	delete(systemdListeners, name)
+
+	return lis, systemdErr
+}
+
+// systemdListenersFromEnv creates the listeners from LISTEN_FDS and LISTEN_FDNAMES like sd_listen_fds_with_names,
+// the file descriptors of other names are closed and the environment variables are unset
+func systemdListenersFromEnv() (map[string]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make(map[string]net.Listener)
+	for i := 0; i < count; i++ {
+		fd := sdListenFDsStart + i
+		if i >= len(names) || names[i] != grpcListenerName && names[i] != httpListenerName {
+			// the unused file descriptors are not leaked
+			os.NewFile(uintptr(fd), "unused").Close()
+			continue
+		}
+
+		lis, err := fileListener(fd, names[i])
+		if err != nil {
+			return nil, err
+		}
+		listeners[names[i]] = lis
+	}
+
+	return listeners, nil
+}
+
+// sdNotify sends the state to systemd over NOTIFY_SOCKET like sd_notify, it does nothing if the socket
+// is not set
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// the abstract socket starts with @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+
+	return err
+}
+
+// sdWatchdogInterval returns the interval of WATCHDOG=1 notifications which is half of WATCHDOG_USEC,
+// it returns 0 if the watchdog is not enabled for the process
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// notifySystemdReady tells systemd that the service is ready and keeps the watchdog alive until the
+// shutdown finishes, MAINPID is sent as well so that the new process in graceful restart is tracked
+func (s *Service) notifySystemdReady() {
+	if err := sdNotify(fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid())); err != nil {
+		s.log.logf(logLevelWarn, "Failed to notify systemd: %v", err)
+	}
+
+	interval := sdWatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := sdNotify("WATCHDOG=1"); err != nil {
+					s.log.logf(logLevelWarn, "Failed to notify systemd watchdog: %v", err)
+				}
+			case <-s.shutdownDone:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,148 @@
+package micro
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// listenNotifySocket listens on a temporary NOTIFY_SOCKET, the returned function cleans it up
+func listenNotifySocket(t *testing.T) (*net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "systemd")
+	require.NoError(t, err)
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "notify"), Net: "unixgram"})
+	require.NoError(t, err)
+	os.Setenv("NOTIFY_SOCKET", filepath.Join(dir, "notify"))
+
+	return conn, func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// readNotification reads the next notification within 1 second
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	return string(buf[:n])
+}
+
+func TestSdNotify(t *testing.T) {
+	var should = require.New(t)
+
+	// nothing is sent without NOTIFY_SOCKET
+	should.NoError(sdNotify("READY=1"))
+
+	conn, cleanup := listenNotifySocket(t)
+	defer cleanup()
+	should.NoError(sdNotify("READY=1"))
+	should.Equal("READY=1", readNotification(t, conn))
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	var should = require.New(t)
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	should.Equal(time.Duration(0), sdWatchdogInterval())
+
+	os.Setenv("WATCHDOG_USEC", "2000000")
+	should.Equal(time.Second, sdWatchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	should.Equal(time.Duration(0), sdWatchdogInterval())
+}
+
+func TestSystemdListenersFromEnv(t *testing.T) {
+	var should = require.New(t)
+
+	// the listeners are ignored if passed to another process
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	listeners, err := systemdListenersFromEnv()
+	should.NoError(err)
+	should.Empty(listeners)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	defer lis.Close()
+
+	file, err := lis.(*net.TCPListener).File()
+	should.NoError(err)
+	defer file.Close()
+
+	// move the listener to the first fd of socket activation and another listener to the next fd which is
+	// not used by the service, the fds are far from the ones used by go test and the runtime
+	other, err := net.Listen("tcp", "127.0.0.1:0")
+	should.NoError(err)
+	defer other.Close()
+	otherFile, err := other.(*net.TCPListener).File()
+	should.NoError(err)
+	defer otherFile.Close()
+
+	defer func(start int) {
+		sdListenFDsStart = start
+	}(sdListenFDsStart)
+	sdListenFDsStart = 1000
+	for fd := sdListenFDsStart; fd <= sdListenFDsStart+1; fd++ {
+		_, err = unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+		should.Equal(unix.EBADF, err, "fd %d is in use", fd)
+	}
+	should.NoError(unix.Dup2(int(file.Fd()), sdListenFDsStart))
+	should.NoError(unix.Dup2(int(otherFile.Fd()), sdListenFDsStart+1))
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "2")
+	os.Setenv("LISTEN_FDNAMES", "http:other")
+	listeners, err = systemdListenersFromEnv()
+	should.NoError(err)
+	should.Len(listeners, 1)
+	should.Equal(lis.Addr().String(), listeners["http"].Addr().String())
+	_, err = unix.FcntlInt(uintptr(sdListenFDsStart+1), unix.F_GETFD, 0)
+	should.Equal(unix.EBADF, err)
+	should.Empty(os.Getenv("LISTEN_PID"))
+	should.Empty(os.Getenv("LISTEN_FDS"))
+	should.Empty(os.Getenv("LISTEN_FDNAMES"))
+	listeners["http"].Close()
+}
+
+func TestSystemd(t *testing.T) {
+	var should = require.New(t)
+
+	conn, cleanup := listenNotifySocket(t)
+	defer cleanup()
+	os.Setenv("WATCHDOG_USEC", "100000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	s := NewService(Systemd(), PreShutdownDelay(0))
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Start(26888, 26999, noopReverseProxyFunc)
+	}()
+
+	should.Equal("READY=1\nMAINPID="+strconv.Itoa(os.Getpid()), readNotification(t, conn))
+	should.Equal("WATCHDOG=1", readNotification(t, conn))
+
+	s.Stop()
+	should.NoError(<-stopped)
+
+	// the watchdog notifications may be queued before STOPPING=1
+	for {
+		if state := readNotification(t, conn); state != "WATCHDOG=1" {
+			should.Equal("STOPPING=1", state)
+			break
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package micro
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the GUID to compute Sec-WebSocket-Accept, see RFC 6455
+	webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	wsCloseNormal          = 1000
+	wsCloseGoingAway       = 1001
+	wsCloseProtocolError   = 1002
+	wsCloseUnsupportedData = 1003
+	wsCloseNoStatus        = 1005
+	wsCloseInvalidPayload  = 1007
+	wsCloseTooBig          = 1009
+	// the gRPC status codes are mapped to the close codes of the private range by adding the code
+	wsCloseGRPCStatusBase = 4000
+
+	// the payload of the control frames is limited to 125 bytes, the close code takes 2 of them
+	wsMaxControlPayload = 125
+	wsMaxCloseReason    = wsMaxControlPayload - 2
+)
+
+// WebSocketOpts is configures for the WebSocket bridge. The route is called with POST unless the method query
+// parameter is set, each text message is a JSON request and an empty message ends the request stream, each
+// response chunk is sent as a message. The connection is closed with 1000 on success, 4000 plus the gRPC code
+// on error and 1001 on shutdown
+type WebSocketOpts struct {
+	// PingInterval is the interval of the pings sent to the clients, default is 30 seconds
+	PingInterval time.Duration
+	// PongTimeout is the time to wait for the pong or any other frame after the ping, the connection is
+	// closed once exceeded, it also limits the time to write a frame, default is 10 seconds
+	PongTimeout time.Duration
+}
+
+// ensureDefaults sets the default values if not set
+func (o *WebSocketOpts) ensureDefaults() {
+	if o.PingInterval == 0 {
+		o.PingInterval = 30 * time.Second
+	}
+	if o.PongTimeout == 0 {
+		o.PongTimeout = 10 * time.Second
+	}
+}
+
+// wsCloseError is the close frame received from the peer, or the violation of the protocol found locally
+// which should be sent to the peer
+type wsCloseError struct {
+	code   int
+	reason string
+	local  bool
+}
+
+func (e *wsCloseError) Error() string {
+	if e.reason == "" {
+		return fmt.Sprintf("websocket closed with %d", e.code)
+	}
+
+	return fmt.Sprintf("websocket closed with %d: %s", e.code, e.reason)
+}
+
+// wsConn is the minimal WebSocket connection of RFC 6455 without extensions, the frames sent by the client
+// are masked
+type wsConn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	client  bool
+	maxSize int
+	// the read deadline is extended by readTimeout on each frame unless closing
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	closing      int32
+	wmu          sync.Mutex
+}
+
+// isWebSocketRequest checks if the request asks to upgrade to WebSocket
+func isWebSocketRequest(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") && headerContainsToken(r.Header, "Upgrade", "websocket")
+}
+
+// headerContainsToken checks if the comma separated values of the header contain the token
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, value := range header[name] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the connection, the error is responded
+// to the client if it fails
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("websocket handshake: method %s is not allowed", r.Method)
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, http.StatusText(http.StatusUpgradeRequired), http.StatusUpgradeRequired)
+		return nil, errors.New("websocket handshake: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, errors.New("websocket handshake: invalid key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, errors.New("websocket handshake: connection can not be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket handshake: %v", err)
+	}
+
+	// the deadlines set by the http server for the request do not apply to the connection
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake: %v", err)
+	}
+
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// readFrame reads a frame and unmasks the payload
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	if c.readTimeout > 0 && atomic.LoadInt32(&c.closing) == 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = header[0]&0x80 != 0
+	op = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &wsCloseError{code: wsCloseProtocolError, reason: "reserved bits are set", local: true}
+	}
+	if masked == c.client {
+		return false, 0, nil, &wsCloseError{code: wsCloseProtocolError, reason: "invalid masking", local: true}
+	}
+
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if op >= wsOpClose && (size > wsMaxControlPayload || !fin) {
+		return false, 0, nil, &wsCloseError{code: wsCloseProtocolError, reason: "invalid control frame", local: true}
+	}
+	if c.maxSize > 0 && size > uint64(c.maxSize) {
+		return false, 0, nil, &wsCloseError{code: wsCloseTooBig, reason: "message is too large", local: true}
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, op, payload, nil
+}
+
+// readMessage reads the next text or binary message, the pings are answered and the pongs are ignored.
+// It returns wsCloseError once the close frame is received
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var op byte
+	var message []byte
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frameOp {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			e := &wsCloseError{code: wsCloseNoStatus}
+			if len(payload) >= 2 {
+				e.code = int(binary.BigEndian.Uint16(payload))
+				e.reason = string(payload[2:])
+			}
+			return 0, nil, e
+		case wsOpText, wsOpBinary:
+			if op != 0 {
+				return 0, nil, &wsCloseError{code: wsCloseProtocolError, reason: "unexpected data frame", local: true}
+			}
+			op = frameOp
+		case wsOpContinuation:
+			if op == 0 {
+				return 0, nil, &wsCloseError{code: wsCloseProtocolError, reason: "unexpected continuation frame", local: true}
+			}
+		default:
+			return 0, nil, &wsCloseError{code: wsCloseProtocolError, reason: "unknown opcode", local: true}
+		}
+
+		message = append(message, payload...)
+		if c.maxSize > 0 && len(message) > c.maxSize {
+			return 0, nil, &wsCloseError{code: wsCloseTooBig, reason: "message is too large", local: true}
+		}
+		if !fin {
+			continue
+		}
+
+		if op == wsOpText && !utf8.Valid(message) {
+			return 0, nil, &wsCloseError{code: wsCloseInvalidPayload, reason: "invalid UTF-8 text", local: true}
+		}
+
+		return op, message, nil
+	}
+}
+
+// writeFrame writes a final frame, the payload is masked if it is the client
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	frame := make([]byte, 2, 14+len(payload))
+	frame[0] = 0x80 | op
+	switch size := len(payload); {
+	case size < 126:
+		frame[1] = byte(size)
+	case size <= 0xffff:
+		frame[1] = 126
+		frame = append(frame, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(size))
+	default:
+		frame[1] = 127
+		frame = append(frame, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(size))
+	}
+
+	if c.client {
+		frame[1] |= 0x80
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range frame[start:] {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	_, err := c.conn.Write(frame)
+
+	return err
+}
+
+// writeClose writes the close frame, the reason is truncated to fit in the frame
+func (c *wsConn) writeClose(code int, reason string) error {
+	if len(reason) > wsMaxCloseReason {
+		reason = strings.ToValidUTF8(reason[:wsMaxCloseReason], "")
+	}
+
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+
+	return c.writeFrame(wsOpClose, append(payload, reason...))
+}
+
+// closeRead stops extending the read deadline and waits at most the timeout for the peer
+func (c *wsConn) closeRead(timeout time.Duration) {
+	atomic.StoreInt32(&c.closing, 1)
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// webSocketMiddleware bridges the WebSocket connections to the streaming routes of the gateway, other requests
+// are passed to the next
+func (s *Service) webSocketMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the browsers do not apply the same-origin policy to WebSocket
+		if !s.webSocketOriginAllowed(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		ctx, ok := s.grpcHTTP.begin(r.Context())
+		if !ok {
+			http.Error(w, "service is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.grpcHTTP.end()
+
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			s.log.logf(logLevelDebug, "Failed to upgrade %s to WebSocket: %v", r.URL.Path, err)
+			return
+		}
+		conn.maxSize = s.serverConfig.MaxRecvMsgSize
+		conn.readTimeout = s.webSocket.PingInterval + s.webSocket.PongTimeout
+		conn.writeTimeout = s.webSocket.PongTimeout
+
+		s.bridgeWebSocket(ctx, conn, r, next)
+	})
+}
+
+// webSocketOriginAllowed allows the clients without Origin, the same origin and the origins allowed by CORS
+func (s *Service) webSocketOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || (s.cors != nil && s.cors.allowed(origin)) {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// bridgeWebSocket serves the upgraded connection by the gateway, the messages from the client are written to
+// the request body as newline delimited JSON and an empty message ends the request stream, the chunks flushed
+// by the gateway are sent as the messages. The connection is closed with the status of the call
+func (s *Service) bridgeWebSocket(ctx context.Context, conn *wsConn, r *http.Request, next http.Handler) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req := r.Clone(ctx)
+	req.Method = http.MethodPost
+	query := req.URL.Query()
+	if method := query.Get("method"); method != "" {
+		req.Method = strings.ToUpper(method)
+		query.Del("method")
+		req.URL.RawQuery = query.Encode()
+	}
+	for name := range req.Header {
+		if name == "Connection" || name == "Upgrade" || strings.HasPrefix(name, "Sec-Websocket-") {
+			req.Header.Del(name)
+		}
+	}
+	pr, pw := io.Pipe()
+	req.Body = pr
+	req.ContentLength = -1
+
+	// the reads of the request body fail once the call is cancelled
+	go func() {
+		<-ctx.Done()
+		pr.CloseWithError(ctx.Err())
+	}()
+
+	var readErr error
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		readErr = readWebSocket(conn, pw)
+		cancel()
+	}()
+
+	pingDone := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(s.webSocket.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.writeFrame(wsOpPing, nil); err != nil {
+					return
+				}
+			case <-pingDone:
+				return
+			}
+		}
+	}()
+
+	rw := &webSocketResponseWriter{conn: conn, header: make(http.Header)}
+	next.ServeHTTP(rw, req)
+	pr.Close()
+	close(pingDone)
+
+	select {
+	case <-readDone:
+		// the client closed the connection or violated the protocol, the close frame is echoed
+		if e, ok := readErr.(*wsCloseError); ok {
+			code := e.code
+			if code == wsCloseNoStatus {
+				code = wsCloseNormal
+			}
+			conn.writeClose(code, e.reason)
+		}
+	default:
+		st := rw.status()
+		rw.send()
+		code, reason := wsCloseNormal, ""
+		if s.grpcHTTP.isClosed() {
+			code, reason = wsCloseGoingAway, "service is shutting down"
+		} else if st.Code() != codes.OK {
+			code, reason = wsCloseGRPCStatusBase+int(st.Code()), st.Message()
+		}
+		conn.writeClose(code, reason)
+		conn.closeRead(s.webSocket.PongTimeout)
+		<-readDone
+	}
+
+	conn.conn.Close()
+}
+
+// readWebSocket writes the messages to the request body until the connection is closed
+func readWebSocket(conn *wsConn, pw *io.PipeWriter) error {
+	for {
+		op, message, err := conn.readMessage()
+		if err != nil {
+			pw.CloseWithError(err)
+			return err
+		}
+		if op == wsOpBinary {
+			err := &wsCloseError{code: wsCloseUnsupportedData, reason: "binary messages are not supported", local: true}
+			pw.CloseWithError(err)
+			return err
+		}
+
+		// the messages are dropped once the request stream ends
+		if len(message) == 0 {
+			pw.Close()
+		} else {
+			pw.Write(append(message, '\n'))
+		}
+	}
+}
+
+// webSocketResponseWriter sends the chunks of the gateway response as the messages once flushed
+type webSocketResponseWriter struct {
+	conn   *wsConn
+	header http.Header
+	code   int
+	// the chunk written since the last flush, the gateway writes the error chunk of the stream and the unary
+	// response without flushing
+	buf []byte
+	err error
+}
+
+// Header implements http.ResponseWriter interface, the headers can not be sent after the handshake
+func (rw *webSocketResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+// WriteHeader implements http.ResponseWriter interface
+func (rw *webSocketResponseWriter) WriteHeader(code int) {
+	if rw.code == 0 {
+		rw.code = code
+	}
+}
+
+// Write implements http.ResponseWriter interface, it fails once the connection fails so that the gateway
+// stops streaming
+func (rw *webSocketResponseWriter) Write(b []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	if rw.err != nil {
+		return 0, rw.err
+	}
+	rw.buf = append(rw.buf, b...)
+
+	return len(b), nil
+}
+
+// Flush implements http.Flusher interface which is required by the gateway to stream
+func (rw *webSocketResponseWriter) Flush() {
+	rw.send()
+}
+
+// send sends the buffered chunk without the delimiter as a message
+func (rw *webSocketResponseWriter) send() {
+	message := bytes.TrimSuffix(rw.buf, []byte("\n"))
+	rw.buf = nil
+	if len(message) == 0 || rw.err != nil {
+		return
+	}
+
+	rw.err = rw.conn.writeFrame(wsOpText, message)
+}
+
+// status returns the status of the call from the final unflushed chunk, it is the error chunk of the stream
+// or the error response. The flushed messages are the results so that they are never taken as the status
+func (rw *webSocketResponseWriter) status() *status.Status {
+	streamed := rw.header.Get("Transfer-Encoding") == "chunked"
+	if !streamed && rw.code < http.StatusBadRequest {
+		return status.New(codes.OK, "")
+	}
+
+	body := rw.buf
+	var chunk struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &chunk) == nil && len(chunk.Error) > 0 {
+		body = chunk.Error
+	} else if rw.code < http.StatusBadRequest {
+		return status.New(codes.OK, "")
+	}
+
+	// the detail is the message of the problem details rendered by ProblemJSONErrorHandler
+	var st struct {
+		Code    codes.Code `json:"code"`
+		Message string     `json:"message"`
+		Detail  string     `json:"detail"`
+	}
+	if json.Unmarshal(body, &st) != nil || st.Code == codes.OK {
+		return status.New(codes.Unknown, http.StatusText(rw.code))
+	}
+	if st.Message == "" {
+		st.Message = st.Detail
+	}
+
+	return status.New(st.Code, st.Message)
+}
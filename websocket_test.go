@@ -0,0 +1,194 @@
+package micro
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// dialWebSocket completes the opening handshake as the client
+func dialWebSocket(t *testing.T, addr string, path string, header http.Header) (*wsConn, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	req, err := http.NewRequest("GET", "http://"+addr+path, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	require.NoError(t, req.Write(conn))
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	require.NoError(t, err)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, resp
+	}
+
+	return &wsConn{conn: conn, br: br, client: true}, resp
+}
+
+// echoStreamRoute echoes the stream of JSON objects like a bidirectional streaming method of the gateway,
+// the message {"text":"fail"} fails the call
+func echoStreamRoute(s *Service) Route {
+	return Route{
+		Method: "POST",
+		Path:   "/v1/echo",
+		Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			_, marshaler := runtime.MarshalerForRequest(s.mux, r)
+			dec := marshaler.NewDecoder(r.Body)
+			recv := func() (proto.Message, error) {
+				var msg structpb.Struct
+				if err := dec.Decode(&msg); err != nil {
+					return nil, err
+				}
+				if msg.Fields["text"].GetStringValue() == "fail" {
+					return nil, status.Error(codes.FailedPrecondition, "echo failed")
+				}
+				return &msg, nil
+			}
+
+			ctx := runtime.NewServerMetadataContext(r.Context(), runtime.ServerMetadata{})
+			runtime.ForwardResponseStream(ctx, s.mux, marshaler, w, r, recv)
+		},
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	var should = require.New(t)
+
+	_, err := New(WebSocket(WebSocketOpts{PingInterval: -1}))
+	should.EqualError(err, "websocket ping interval and pong timeout must not be negative")
+
+	s := NewService(WebSocket(WebSocketOpts{PingInterval: 100 * time.Millisecond}), PreShutdownDelay(0))
+	s.AddRoutes(echoStreamRoute(s), Route{
+		Method: "POST",
+		Path:   "/v1/report",
+		Handler: func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+			w.Write([]byte(`{"error":{"code":5,"message":"not found"}}`))
+		},
+	})
+	go s.Start(16888, 16999, noopReverseProxyFunc)
+	defer s.Stop()
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	// the messages are echoed in both directions and the empty message ends the request stream
+	conn, resp := dialWebSocket(t, "127.0.0.1:16888", "/v1/echo", nil)
+	should.Equal(http.StatusSwitchingProtocols, resp.StatusCode)
+	should.NoError(conn.writeFrame(wsOpText, []byte(`{"text":"a"}`)))
+	op, message, err := conn.readMessage()
+	should.NoError(err)
+	should.Equal(byte(wsOpText), op)
+	should.JSONEq(`{"result":{"text":"a"}}`, string(message))
+
+	// the pings are sent to the client and answered by readMessage
+	time.Sleep(300 * time.Millisecond)
+
+	should.NoError(conn.writeFrame(wsOpText, []byte(`{"text":"b"}`)))
+	_, message, err = conn.readMessage()
+	should.NoError(err)
+	should.JSONEq(`{"result":{"text":"b"}}`, string(message))
+
+	should.NoError(conn.writeFrame(wsOpText, nil))
+	_, _, err = conn.readMessage()
+	should.Equal(&wsCloseError{code: wsCloseNormal}, err)
+	should.NoError(conn.writeClose(wsCloseNormal, ""))
+	conn.conn.Close()
+
+	// the status is mapped to the close code after the error chunk
+	conn, _ = dialWebSocket(t, "127.0.0.1:16888", "/v1/echo", nil)
+	should.NoError(conn.writeFrame(wsOpText, []byte(`{"text":"fail"}`)))
+	_, message, err = conn.readMessage()
+	should.NoError(err)
+	should.Contains(string(message), `"error"`)
+	_, _, err = conn.readMessage()
+	should.Equal(&wsCloseError{code: wsCloseGRPCStatusBase + int(codes.FailedPrecondition), reason: "echo failed"}, err)
+	should.NoError(conn.writeClose(wsCloseNormal, ""))
+	conn.conn.Close()
+
+	// the error response of the unknown route
+	conn, _ = dialWebSocket(t, "127.0.0.1:16888", "/v1/unknown", nil)
+	_, _, err = conn.readMessage()
+	should.NoError(err)
+	_, _, err = conn.readMessage()
+	should.Equal(wsCloseGRPCStatusBase+int(codes.NotFound), err.(*wsCloseError).code)
+	should.NoError(conn.writeClose(wsCloseNormal, ""))
+	conn.conn.Close()
+
+	// the response with the error field is not the error of the call
+	conn, _ = dialWebSocket(t, "127.0.0.1:16888", "/v1/report", nil)
+	_, message, err = conn.readMessage()
+	should.NoError(err)
+	should.JSONEq(`{"error":{"code":5,"message":"not found"}}`, string(message))
+	_, _, err = conn.readMessage()
+	should.Equal(&wsCloseError{code: wsCloseNormal}, err)
+	should.NoError(conn.writeClose(wsCloseNormal, ""))
+	conn.conn.Close()
+
+	// the binary messages are rejected
+	conn, _ = dialWebSocket(t, "127.0.0.1:16888", "/v1/echo", nil)
+	should.NoError(conn.writeFrame(wsOpBinary, []byte{1}))
+	_, _, err = conn.readMessage()
+	should.Equal(wsCloseUnsupportedData, err.(*wsCloseError).code)
+	conn.conn.Close()
+
+	// the cross origin requests are forbidden unless allowed by CORS
+	_, resp = dialWebSocket(t, "127.0.0.1:16888", "/v1/echo", http.Header{"Origin": {"https://example.com"}})
+	should.Equal(http.StatusForbidden, resp.StatusCode)
+	conn, resp = dialWebSocket(t, "127.0.0.1:16888", "/v1/echo", http.Header{"Origin": {"http://127.0.0.1:16888"}})
+	should.Equal(http.StatusSwitchingProtocols, resp.StatusCode)
+	conn.conn.Close()
+}
+
+func TestWebSocketShutdown(t *testing.T) {
+	var should = require.New(t)
+
+	s := NewService(WebSocket(WebSocketOpts{}), PreShutdownDelay(0))
+	s.AddRoutes(echoStreamRoute(s))
+	go s.Start(15888, 15999, noopReverseProxyFunc)
+
+	// wait 1 second for the server start
+	time.Sleep(1 * time.Second)
+
+	conn, _ := dialWebSocket(t, "127.0.0.1:15888", "/v1/echo", nil)
+	defer conn.conn.Close()
+	should.NoError(conn.writeFrame(wsOpText, []byte(`{"text":"a"}`)))
+	_, _, err := conn.readMessage()
+	should.NoError(err)
+
+	// the stream in flight is closed with going away on shutdown
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+	for {
+		_, _, err = conn.readMessage()
+		if err != nil {
+			break
+		}
+	}
+	should.Equal(wsCloseGoingAway, err.(*wsCloseError).code)
+	should.NoError(conn.writeClose(wsCloseGoingAway, ""))
+	should.NoError(<-done)
+}